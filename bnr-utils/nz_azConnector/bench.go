@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// bench uploads and downloads synthetic files under a scratch prefix of the
+// uniqueid with every setting of -bench-matrix, using the same upload and
+// download functions as backups, reports the throughput, request count and
+// memory of each and recommends the fastest. The scratch blobs and files are
+// removed afterwards.
+func (cn *Conn) bench(dir string, othargs OtherArgs) error {
+	matrix, err := nzbackup.ParseBenchMatrix(othargs.benchMatrix, nzbackup.BenchSetting{
+		Streams: int(cn.streams), BlockSize: int(cn.blocksize), ParallelJobs: othargs.paralleljobs})
+	if err != nil {
+		return err
+	}
+	if othargs.benchSize <= 0 {
+		return fmt.Errorf("-bench-size must be a positive number of MB")
+	}
+	size := othargs.benchSize * 1024 * 1024
+
+	// with -dir, the local disk of the backups is part of the benchmark
+	localdir, err := os.MkdirTemp(dir, "nzbench")
+	if err != nil {
+		return fmt.Errorf("Unable to create scratch directory: %v", err)
+	}
+	defer os.RemoveAll(localdir)
+	files, err := nzbackup.WriteBenchFiles(localdir, nzbackup.MaxParallelJobs(matrix), size)
+	if err != nil {
+		return fmt.Errorf("Unable to write benchmark data: %v", err)
+	}
+
+	// scratch blobs never get an immutability policy, so that they can be deleted
+	bench := *cn
+	bench.retainUntilDate = nil
+	bench.legalHold = false
+	scratch := nzbackup.BenchPath()
+	log.Printf("Benchmarking %d settings with %s files under %s in container %s",
+		len(matrix), nzbackup.FormatSize(size), path.Join(othargs.uniqueid, scratch), cn.azcontainer)
+
+	var results []nzbackup.BenchResult
+	var benchErr error
+	for _, setting := range matrix {
+		result := nzbackup.BenchResult{Setting: setting, Bytes: int64(setting.ParallelJobs) * size}
+		sampler := nzbackup.SampleMemory()
+		transfer := func(fn func(i int) error) (time.Duration, int64, error) {
+			start, before := time.Now(), cn.metrics.Requests()
+			errs := make([]error, setting.ParallelJobs)
+			var wg sync.WaitGroup
+			for i := 0; i < setting.ParallelJobs; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs[i] = fn(i)
+				}()
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					return 0, 0, err
+				}
+			}
+			return time.Since(start), cn.metrics.Requests() - before, nil
+		}
+
+		result.Upload, result.UploadRequests, err = transfer(func(i int) error {
+			relpath := path.Join(scratch, filepath.Base(files[i]))
+			return bench.uploadFile(files[i], relpath, othargs.uniqueid, uint(setting.Streams), int64(setting.BlockSize))
+		})
+		if err == nil {
+			result.Download, result.DownloadRequests, err = transfer(func(i int) error {
+				blobname := path.Join(othargs.uniqueid, scratch, filepath.Base(files[i]))
+				return bench.downloadFile(files[i]+".download", blobname, uint(setting.Streams), int64(setting.BlockSize))
+			})
+		}
+		result.PeakMemory = sampler.Stop()
+		if err != nil {
+			benchErr = fmt.Errorf("Benchmark with %s failed: %v", setting, err)
+			break
+		}
+		log.Println(result)
+		results = append(results, result)
+	}
+
+	for _, f := range files {
+		blobURL, err := cn.getBlobURL(path.Join(othargs.uniqueid, scratch, filepath.Base(f)))
+		if err == nil {
+			_, err = blobURL.Delete(context.Background(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+		}
+		if stgErr, ok := err.(azblob.StorageError); ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			continue
+		}
+		if err != nil {
+			log.Printf("Unable to delete scratch blob of %s: %v", f, err)
+		}
+	}
+	if benchErr != nil {
+		return benchErr
+	}
+	if best, ok := nzbackup.RecommendBench(results); ok {
+		up, down := best.Throughput()
+		log.Printf("Recommended: %s (upload %.1f MB/s, download %.1f MB/s)", best.Setting, up, down)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"path"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// explainStorageError adds the likely cause to the errors of the -check steps.
+func explainStorageError(err error) error {
+	stgErr, ok := err.(azblob.StorageError)
+	if !ok {
+		return err
+	}
+	switch stgErr.ServiceCode() {
+	case azblob.ServiceCodeAuthenticationFailed:
+		return fmt.Errorf("the key is not valid for the storage account: %w", err)
+	case azblob.ServiceCodeContainerNotFound:
+		return fmt.Errorf("the container does not exist: %w", err)
+	case "AuthorizationFailure", "AuthorizationPermissionMismatch", "InsufficientAccountPermissions":
+		return fmt.Errorf("permission denied: %w", err)
+	}
+	return err
+}
+
+// check validates the credentials, the account endpoint, the container and
+// every permission the connector needs by writing, reading and deleting probe
+// blobs under the uniqueid, and reports which step failed.
+func (cn *Conn) check(uniqueid string) error {
+	if cn.azaccount == "" || cn.azkey == "" || cn.azcontainer == "" {
+		return fmt.Errorf("Missing required field: storage-account, key and container are required with -check")
+	}
+	ctx := context.Background()
+	probe := path.Join(uniqueid, nzbackup.ProbePath())
+	blockProbe := probe + "-blocks"
+	data := nzbackup.ProbeData(probe)
+	log.Printf("Checking access to container %s of storage account %s with probe blob %s", cn.azcontainer, cn.azaccount, probe)
+
+	var p nzbackup.Preflight
+	run := func(name string, requires []string, fn func() error) {
+		log.Println(p.Run(name, requires, func() error {
+			return explainStorageError(fn())
+		}))
+	}
+
+	run("credentials", nil, func() error {
+		_, err := azblob.NewSharedKeyCredential(cn.azaccount, cn.azkey)
+		if err != nil {
+			return fmt.Errorf("the key must be the base64 access key of the storage account: %w", err)
+		}
+		return nil
+	})
+	run("endpoint", nil, func() error {
+		u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/", cn.azaccount))
+		if err != nil {
+			return err
+		}
+		if _, err := net.LookupHost(u.Hostname()); err != nil {
+			return fmt.Errorf("storage account %s not found: %w", cn.azaccount, err)
+		}
+		return nil
+	})
+	run("container", []string{"credentials", "endpoint"}, func() error {
+		containerURL, err := cn.getContainerURL()
+		if err != nil {
+			return err
+		}
+		_, err = containerURL.GetProperties(ctx, azblob.LeaseAccessConditions{})
+		return err
+	})
+	run("list", []string{"container"}, func() error {
+		containerURL, err := cn.getContainerURL()
+		if err != nil {
+			return err
+		}
+		_, err = containerURL.ListBlobsFlatSegment(ctx, azblob.Marker{}, azblob.ListBlobsSegmentOptions{
+			Prefix:     uniqueid + "/",
+			MaxResults: 1,
+		})
+		return err
+	})
+	run("put", []string{"container"}, func() error {
+		blockBlobURL, err := cn.getBlockBlobURL(probe)
+		if err != nil {
+			return err
+		}
+		_, err = azblob.UploadBufferToBlockBlob(ctx, data, blockBlobURL, azblob.UploadToBlockBlobOptions{})
+		return err
+	})
+	run("get", []string{"put"}, func() error {
+		got, found, err := cn.readBlob(probe)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("probe blob %s not found after upload", probe)
+		}
+		if !bytes.Equal(got, data) {
+			return fmt.Errorf("read back %d bytes that differ from the %d bytes written", len(got), len(data))
+		}
+		return nil
+	})
+	run("block upload", []string{"container"}, func() error {
+		// large backup files are uploaded as staged blocks committed at the end
+		blockBlobURL, err := cn.getBlockBlobURL(blockProbe)
+		if err != nil {
+			return err
+		}
+		blockID := base64.StdEncoding.EncodeToString([]byte("nzcheck-block-0000"))
+		_, err = blockBlobURL.StageBlock(ctx, blockID, bytes.NewReader(data), azblob.LeaseAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return err
+		}
+		_, err = blockBlobURL.CommitBlockList(ctx, []string{blockID}, azblob.BlobHTTPHeaders{}, azblob.Metadata{}, azblob.BlobAccessConditions{},
+			azblob.DefaultAccessTier, nil, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+		return err
+	})
+	run("delete", []string{"put"}, func() error {
+		for _, name := range []string{probe, blockProbe} {
+			blobURL, err := cn.getBlobURL(name)
+			if err != nil {
+				return err
+			}
+			_, err = blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+			if stgErr, ok := err.(azblob.StorageError); ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+				// the block upload failed
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err := p.Err(); err != nil {
+		return err
+	}
+	log.Printf("Pre-flight check of container %s passed", cn.azcontainer)
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// manifestParameters returns the connector parameters recorded in manifests.
+func (cn *Conn) manifestParameters() map[string]string {
+	return map[string]string{
+		"connector":   "nz_azConnector",
+		"blocksize":   strconv.FormatInt(cn.blocksize, 10),
+		"streams":     strconv.FormatUint(uint64(cn.streams), 10),
+		"compression": "none",
+		"encryption":  "none",
+	}
+}
+
+func (cn *Conn) uploadBuffer(data []byte, uniqueid string, relfilepath string) error {
+	blockBlobURL, err := cn.getBlockBlobURL(uniqueid + "/" + relfilepath)
+	if err != nil {
+		return err
+	}
+	_, err = azblob.UploadBufferToBlockBlob(context.Background(), data, blockBlobURL, cn.uploadOptions(relfilepath, cn.streams, cn.blocksize))
+	if err != nil {
+		return fmt.Errorf("Unable to upload blob %s: %v", relfilepath, err)
+	}
+	return nil
+}
+
+// uploadCommits writes the manifest and then the commit blob of every
+// uploaded backupset. It must only be called once all files of the run were
+// uploaded successfully.
+func (cn *Conn) uploadCommits(uniqueid string, uploaded *nzbackup.UploadSet) error {
+	for bsdir, manifest := range uploaded.Manifests(cn.manifestParameters()) {
+		data, err := manifest.Marshal()
+		if err != nil {
+			return fmt.Errorf("Unable to create manifest for %s: %v", bsdir, err)
+		}
+		err = cn.uploadBuffer(data, uniqueid, nzbackup.ManifestPath(bsdir))
+		if err != nil {
+			return err
+		}
+
+		data, err = nzbackup.NewCommit(manifest).Marshal()
+		if err != nil {
+			return fmt.Errorf("Unable to create commit blob for %s: %v", bsdir, err)
+		}
+		err = cn.uploadBuffer(data, uniqueid, nzbackup.CommitPath(bsdir))
+		if err != nil {
+			return err
+		}
+		log.Printf("Manifest and commit blob uploaded for %d files of %s", len(manifest.Files), bsdir)
+	}
+	return nil
+}
+
+// readBlob returns the content of a small blob. found is false when the blob does not exist.
+func (cn *Conn) readBlob(blobname string) (data []byte, found bool, err error) {
+	blobURL, err := cn.getBlobURL(blobname)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := blobURL.Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if stgErr, ok := err.(azblob.StorageError); ok && stgErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to download blob %s: %v", blobname, err)
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 20})
+	defer body.Close()
+	data, err = io.ReadAll(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to download blob %s: %v", blobname, err)
+	}
+	return data, true, nil
+}
+
+func (cn *Conn) readCommit(blobname string) (nzbackup.Commit, error) {
+	data, found, err := cn.readBlob(blobname)
+	if err == nil && !found {
+		err = fmt.Errorf("Commit blob %s not found", blobname)
+	}
+	if err != nil {
+		return nzbackup.Commit{}, err
+	}
+	return nzbackup.ParseCommit(data)
+}
+
+// selectBackupBlobs returns the names of the backup blobs to download. When
+// blobpath is a single backupset with a manifest, the names come from the
+// manifest, which is returned as well. Otherwise the backup is listed and
+// checked for completeness. The modification time of every blob, as recorded
+// in the manifest, is returned to locate increments in time. It is nil
+// without a manifest, as the upload time of a blob says nothing of when its
+// increment was backed up.
+func (cn *Conn) selectBackupBlobs(uniqueid string, blobpath string, allowIncomplete bool) ([]string, map[string]time.Time, *nzbackup.Manifest, error) {
+	relbkpath, err := filepath.Rel(uniqueid, blobpath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Error in fetching download relative path: %v", err)
+	}
+
+	if nzbackup.IsBackupsetDir(relbkpath) {
+		data, found, err := cn.readBlob(blobpath + "/" + nzbackup.ManifestName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if found {
+			manifest, err := nzbackup.ParseManifest(data)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			_, committed, err := cn.readBlob(blobpath + "/" + nzbackup.CommitName)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if !committed {
+				err = reportIncomplete(cn.azcontainer, []string{relbkpath + " has a manifest but no commit blob, its upload may not have completed"}, allowIncomplete)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+			}
+			log.Printf("Using manifest of %s listing %d files", blobpath, len(manifest.Files))
+			blobnames := make([]string, 0, len(manifest.Files))
+			modtimes := make(map[string]time.Time, len(manifest.Files))
+			for _, f := range manifest.Files {
+				blobname := blobpath + "/" + f.Path
+				blobnames = append(blobnames, blobname)
+				modtimes[blobname] = f.ModTime
+			}
+			return blobnames, modtimes, &manifest, nil
+		}
+	}
+
+	blobnames := []string{}
+	err = cn.listBackupBlobs(blobpath, func(blobInfo azblob.BlobItemInternal) error {
+		blobnames = append(blobnames, blobInfo.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(blobnames) == 0 {
+		return nil, nil, nil, fmt.Errorf("No matching blob found. Please check if DB name, hostname, uniqueid or containername are correct. If error persists contact IBM support team. Azaccount:%s AzContainer:%s Blobpath:%s, Uniqueid:%s", cn.azaccount, cn.azcontainer, blobpath, uniqueid)
+	}
+
+	err = cn.checkComplete(uniqueid, blobnames, allowIncomplete)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	files := blobnames[:0]
+	for _, blobname := range blobnames {
+		if !nzbackup.IsControlPath(blobname) {
+			files = append(files, blobname)
+		}
+	}
+	return files, nil, nil, nil
+}
+
+// checkComplete fails when a listed backupset has no commit blob or misses
+// files, unless allowIncomplete is set.
+func (cn *Conn) checkComplete(uniqueid string, blobnames []string, allowIncomplete bool) error {
+	relpaths := make([]string, 0, len(blobnames))
+	for _, blobname := range blobnames {
+		relpath, err := filepath.Rel(uniqueid, blobname)
+		if err != nil {
+			return fmt.Errorf("Error in fetching download relative path: %v", err)
+		}
+		relpaths = append(relpaths, relpath)
+	}
+
+	problems, legacy, err := nzbackup.CheckComplete(relpaths, func(commitpath string) (nzbackup.Commit, error) {
+		return cn.readCommit(uniqueid + "/" + commitpath)
+	})
+	if err != nil {
+		return err
+	}
+	for _, warning := range legacy {
+		log.Println("WARNING:", warning)
+	}
+	return reportIncomplete(cn.azcontainer, problems, allowIncomplete)
+}
+
+func reportIncomplete(container string, problems []string, allowIncomplete bool) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, problem := range problems {
+		log.Println("WARNING:", problem)
+	}
+	if !allowIncomplete {
+		return fmt.Errorf("Backup in container %s is incomplete. Use -allow-incomplete to download it anyway", container)
+	}
+	log.Println("WARNING: downloading incomplete backup because -allow-incomplete is set")
+	return nil
+}
+
+// verifyDownload checks the downloaded files against the manifest and
+// restores their modification times and permissions. locate maps manifest
+// paths to local files.
+func verifyDownload(manifest *nzbackup.Manifest, locate func(path string) (string, bool), checksum bool) error {
+	problems := manifest.Verify(locate, checksum)
+	for _, problem := range problems {
+		log.Println("ERROR:", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d downloaded files do not match the manifest", len(problems))
+	}
+	err := manifest.ApplyAttributes(locate)
+	if err != nil {
+		return fmt.Errorf("Unable to restore file attributes from manifest: %v", err)
+	}
+	log.Printf("All %d files match the manifest", len(manifest.Files))
+	return nil
+}
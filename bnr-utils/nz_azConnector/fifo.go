@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// fifoFile is a data file streamed into a named pipe instead of written to disk.
+type fifoFile struct {
+	blobname  string
+	relpath   string
+	localpath string
+}
+
+type fifoResult struct {
+	fifo fifoFile
+	file nzbackup.ManifestFile
+	err  error
+}
+
+// serveFifos creates a named pipe for every data file and streams the blob
+// into it once nzrestore opens it. It returns when every pipe has been read
+// to the end, after comparing what was streamed with the manifest.
+func (cn *Conn) serveFifos(fifos []fifoFile, manifest *nzbackup.Manifest, checksum bool) error {
+	for _, f := range fifos {
+		err := nzbackup.MakeFifo(f.localpath)
+		if err != nil {
+			return fmt.Errorf("Error in creating named pipe %s: %v", f.localpath, err)
+		}
+	}
+	log.Printf("Created %d named pipes, waiting for nzrestore to read them", len(fifos))
+
+	result := make(chan *fifoResult, len(fifos))
+	for _, f := range fifos {
+		go func() {
+			file, err := cn.streamToFifo(f, checksum)
+			result <- &fifoResult{fifo: f, file: file, err: err}
+		}()
+	}
+
+	// streamed files by manifest path
+	streamed := make(map[string]nzbackup.ManifestFile)
+	for range fifos {
+		r := <-result
+		if r.err != nil {
+			return fmt.Errorf("%s: %v", r.fifo.blobname, r.err)
+		}
+		log.Println("Streamed file :", r.fifo.blobname)
+		if bsdir, ok := nzbackup.BackupsetDir(r.fifo.relpath); ok {
+			streamed[strings.TrimPrefix(filepath.ToSlash(r.fifo.relpath), bsdir+"/")] = r.file
+		}
+	}
+	log.Println("Total files streamed:", len(fifos))
+
+	if manifest == nil {
+		return nil
+	}
+	problems := manifest.VerifyStreams(streamed, checksum)
+	for _, problem := range problems {
+		log.Println("ERROR:", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d streamed files do not match the manifest", len(problems))
+	}
+	return nil
+}
+
+// streamToFifo waits for nzrestore to open the named pipe of a data file and
+// writes the blob into it, reading blocksize MB ranges up to streams ranges
+// ahead of nzrestore.
+func (cn *Conn) streamToFifo(f fifoFile, checksum bool) (nzbackup.ManifestFile, error) {
+	// opening blocks until nzrestore opens the pipe for reading
+	fifo, err := os.OpenFile(f.localpath, os.O_WRONLY, 0)
+	if err != nil {
+		return nzbackup.ManifestFile{}, fmt.Errorf("Error in opening named pipe: %v", err)
+	}
+	defer fifo.Close()
+
+	blobURL, err := cn.getBlobURL(f.blobname)
+	if err != nil {
+		return nzbackup.ManifestFile{}, err
+	}
+	props, err := blobURL.GetProperties(context.Background(), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nzbackup.ManifestFile{}, fmt.Errorf("Error in reading blob properties: %v", err)
+	}
+	ranges := nzbackup.NewRangeReader(props.ContentLength(), cn.blocksize*1024*1024, int(cn.streams),
+		func(offset, count int64) ([]byte, error) {
+			resp, err := blobURL.Download(context.Background(), offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+			if err != nil {
+				return nil, err
+			}
+			body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 20})
+			defer body.Close()
+			return io.ReadAll(body)
+		})
+	defer ranges.Close()
+
+	stream := nzbackup.NewStreamReader(ranges, 0644, checksum)
+	_, err = io.Copy(fifo, stream)
+	if err != nil {
+		return nzbackup.ManifestFile{}, fmt.Errorf("Error in streaming blob into named pipe %s: %v", f.localpath, err)
+	}
+	return stream.File(), nil
+}
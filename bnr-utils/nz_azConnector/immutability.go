@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// prepareImmutability validates the immutability arguments and computes the
+// retention date of the policy applied to every uploaded blob.
+func (cn *Conn) prepareImmutability(othargs OtherArgs) error {
+	if *othargs.requireImmutable {
+		err := cn.checkContainerImmutability()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cn.immutabilityMode == "" {
+		if cn.retainDays != 0 || cn.retainUntil != "" {
+			return fmt.Errorf("Immutability mode is required when -retain-days or -retain-until is set")
+		}
+		return nil
+	}
+
+	mode := azblob.BlobImmutabilityPolicyModeType(strings.ToLower(cn.immutabilityMode))
+	if mode != azblob.BlobImmutabilityPolicyModeUnlocked && mode != azblob.BlobImmutabilityPolicyModeLocked {
+		return fmt.Errorf("Invalid immutability mode %s. Valid values are Unlocked and Locked", cn.immutabilityMode)
+	}
+	cn.immutabilityMode = string(mode)
+
+	switch {
+	case cn.retainDays != 0 && cn.retainUntil != "":
+		return fmt.Errorf("Only one of -retain-days and -retain-until can be set")
+	case cn.retainDays > 0:
+		until := time.Now().UTC().AddDate(0, 0, cn.retainDays)
+		cn.retainUntilDate = &until
+	case cn.retainUntil != "":
+		until, err := time.Parse("2006-01-02", cn.retainUntil)
+		if err != nil {
+			until, err = time.Parse(time.RFC3339, cn.retainUntil)
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid -retain-until %s. Use YYYY-MM-DD or RFC3339 format", cn.retainUntil)
+		}
+		if !until.After(time.Now()) {
+			return fmt.Errorf("Retain until date %s is not in the future", cn.retainUntil)
+		}
+		cn.retainUntilDate = &until
+	default:
+		return fmt.Errorf("Retention period is required, set -retain-days or -retain-until when -immutability-mode is set")
+	}
+	log.Printf("Blobs will be immutable (%s policy) until %s", cn.immutabilityMode, cn.retainUntilDate.Format(time.RFC3339))
+	return nil
+}
+
+// checkContainerImmutability fails unless the container has a container level
+// immutability policy or version level immutability enabled.
+func (cn *Conn) checkContainerImmutability() error {
+	containerURL, err := cn.getContainerURL()
+	if err != nil {
+		return err
+	}
+	props, err := containerURL.GetProperties(context.Background(), azblob.LeaseAccessConditions{})
+	if err != nil {
+		return fmt.Errorf("Unable to read properties of container %s. Ensure azure storage account and container are correct.\n Error details: %v", cn.azcontainer, err)
+	}
+	if props.HasImmutabilityPolicy() != "true" && props.IsImmutableStorageWithVersioningEnabled() != "true" {
+		return fmt.Errorf("Immutable storage is not enabled on container %s", cn.azcontainer)
+	}
+	return nil
+}
+
+// immutabilityPolicyOptions returns the immutability policy and legal hold to
+// place on a blob once its upload finishes.
+func (cn *Conn) immutabilityPolicyOptions() azblob.ImmutabilityPolicyOptions {
+	var opts azblob.ImmutabilityPolicyOptions
+	if cn.retainUntilDate != nil {
+		opts.ImmutabilityPolicyUntilDate = cn.retainUntilDate
+		opts.ImmutabilityPolicyMode = azblob.BlobImmutabilityPolicyModeType(cn.immutabilityMode)
+	}
+	if cn.legalHold {
+		legalHold := true
+		opts.LegalHold = &legalHold
+	}
+	return opts
+}
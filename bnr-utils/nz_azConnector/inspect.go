@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// listObjectInfos lists the backup files stored under blobpath with their sizes.
+func (cn *Conn) listObjectInfos(uniqueid string, blobpath string) ([]nzbackup.ObjectInfo, error) {
+	var objects []nzbackup.ObjectInfo
+	err := cn.listBackupBlobs(blobpath, func(blobInfo azblob.BlobItemInternal) error {
+		if nzbackup.IsControlPath(blobInfo.Name) {
+			return nil
+		}
+		relpath, err := filepath.Rel(uniqueid, blobInfo.Name)
+		if err != nil {
+			return fmt.Errorf("Error in fetching relative path: %v", err)
+		}
+		var size int64
+		if blobInfo.Properties.ContentLength != nil {
+			size = *blobInfo.Properties.ContentLength
+		}
+		objects = append(objects, nzbackup.ObjectInfo{Path: relpath, Size: size, ModTime: blobInfo.Properties.LastModified})
+		return nil
+	})
+	return objects, err
+}
+
+// inspectBackupset summarizes the backupset stored under blobpath by reading
+// only its md files and its manifest, if any.
+func (cn *Conn) inspectBackupset(uniqueid string, blobpath string) (*nzbackup.Inspection, error) {
+	objects, err := cn.listObjectInfos(uniqueid, blobpath)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("No matching blob found. Please check if DB name, hostname, uniqueid or containername are correct. Azaccount:%s AzContainer:%s Blobpath:%s", cn.azaccount, cn.azcontainer, blobpath)
+	}
+
+	var manifest *nzbackup.Manifest
+	data, found, err := cn.readBlob(blobpath + "/" + nzbackup.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		m, err := nzbackup.ParseManifest(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read manifest of %s: %v", blobpath, err)
+		}
+		manifest = &m
+	}
+
+	inspection, err := nzbackup.Inspect(objects, manifest, func(relpath string) ([]byte, error) {
+		data, found, err := cn.readBlob(uniqueid + "/" + relpath)
+		if err == nil && !found {
+			err = fmt.Errorf("%s not found", relpath)
+		}
+		return data, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect backup %s: %v", blobpath, err)
+	}
+	return inspection, nil
+}
+
+// inspect reports the increments, tables and data file sizes of a backupset
+// by reading only its md files.
+func (cn *Conn) inspect(uniqueid string, blobpath string) error {
+	inspection, err := cn.inspectBackupset(uniqueid, blobpath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backupset %s:", blobpath)
+	for _, line := range inspection.Report() {
+		log.Println(line)
+	}
+	return nil
+}
+
+// diff reports the tables added, dropped or changed in size between two
+// backupsets of the database stored under dbpath.
+func (cn *Conn) diff(uniqueid string, dbpath string, backupsets string) error {
+	before, after, err := nzbackup.ParseBackupsetPair(backupsets)
+	if err != nil {
+		return err
+	}
+	// the two backupsets are independent prefixes, inspected concurrently
+	inspections, err := nzbackup.ListConcurrently([]string{before, after}, 2, func(id string) ([]*nzbackup.Inspection, error) {
+		inspection, err := cn.inspectBackupset(uniqueid, dbpath+"/"+id)
+		return []*nzbackup.Inspection{inspection}, err
+	})
+	if err != nil {
+		return err
+	}
+
+	changes, err := nzbackup.DiffTables(inspections[0], inspections[1])
+	if err != nil {
+		return err
+	}
+	log.Printf("Differences from backupset %s to %s: %d", before, after, len(changes))
+	for _, change := range changes {
+		log.Println(change)
+	}
+	return nil
+}
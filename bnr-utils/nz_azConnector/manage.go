@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// listAllBlobs lists every blob stored under blobpath, including the commit
+// and manifest blobs.
+func (cn *Conn) listAllBlobs(uniqueid string, blobpath string) ([]nzbackup.ObjectInfo, error) {
+	var objects []nzbackup.ObjectInfo
+	err := cn.listBackupBlobs(blobpath, func(blobInfo azblob.BlobItemInternal) error {
+		relpath, err := filepath.Rel(uniqueid, blobInfo.Name)
+		if err != nil {
+			return fmt.Errorf("Error in fetching relative path: %v", err)
+		}
+		var size int64
+		if blobInfo.Properties.ContentLength != nil {
+			size = *blobInfo.Properties.ContentLength
+		}
+		objects = append(objects, nzbackup.ObjectInfo{Path: relpath, Size: size, ModTime: blobInfo.Properties.LastModified})
+		return nil
+	})
+	return objects, err
+}
+
+// list reports the backupsets stored under blobpath. With hierarchical, the
+// backupset directories are found with hierarchical listings and listed
+// paralleljobs at a time instead of listing every blob under blobpath at once.
+func (cn *Conn) list(uniqueid string, blobpath string, hierarchical bool, paralleljobs int) error {
+	var objects []nzbackup.ObjectInfo
+	var err error
+	if hierarchical {
+		relpath, _ := filepath.Rel(uniqueid, blobpath)
+		var bsdirs []string
+		bsdirs, err = nzbackup.ListDirs(blobpath, nzbackup.BackupsetLevels(relpath), paralleljobs, cn.listSubdirs)
+		if err != nil {
+			return err
+		}
+		log.Printf("Listing %d backupsets under %s", len(bsdirs), blobpath)
+		objects, err = nzbackup.ListConcurrently(bsdirs, paralleljobs, func(bsdir string) ([]nzbackup.ObjectInfo, error) {
+			return cn.listAllBlobs(uniqueid, bsdir)
+		})
+	} else {
+		objects, err = cn.listAllBlobs(uniqueid, blobpath)
+	}
+	if err != nil {
+		return err
+	}
+	backupsets := nzbackup.ListBackupsets(objects)
+	for _, bs := range backupsets {
+		log.Println(bs)
+	}
+	log.Printf("Total backupsets found under %s: %d", blobpath, len(backupsets))
+	return nil
+}
+
+// verify checks the backupset stored under blobpath against its commit blob
+// and manifest without downloading it.
+func (cn *Conn) verify(uniqueid string, blobpath string) error {
+	objects, err := cn.listAllBlobs(uniqueid, blobpath)
+	if err != nil {
+		return err
+	}
+	var files []nzbackup.ObjectInfo
+	for _, obj := range objects {
+		if !nzbackup.IsControlPath(obj.Path) {
+			files = append(files, obj)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("No matching blob found. Please check if DB name, hostname, uniqueid or containername are correct. Azaccount:%s AzContainer:%s Blobpath:%s", cn.azaccount, cn.azcontainer, blobpath)
+	}
+
+	var commit *nzbackup.Commit
+	data, found, err := cn.readBlob(blobpath + "/" + nzbackup.CommitName)
+	if err != nil {
+		return err
+	}
+	if found {
+		c, err := nzbackup.ParseCommit(data)
+		if err != nil {
+			return err
+		}
+		commit = &c
+	}
+	var manifest *nzbackup.Manifest
+	data, found, err = cn.readBlob(blobpath + "/" + nzbackup.ManifestName)
+	if err != nil {
+		return err
+	}
+	if found {
+		m, err := nzbackup.ParseManifest(data)
+		if err != nil {
+			return err
+		}
+		manifest = &m
+	}
+
+	bsdir, _ := filepath.Rel(uniqueid, blobpath)
+	problems := nzbackup.VerifyBackupset(bsdir, files, commit, manifest)
+	for _, problem := range problems {
+		log.Println("ERROR:", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Backupset %s failed verification, %d problems found", bsdir, len(problems))
+	}
+	log.Printf("Backupset %s verified: all %d files are present with the expected size", bsdir, len(files))
+	return nil
+}
+
+// delete removes every blob of the backupset stored under blobpath. The
+// commit blob goes first so that an interrupted delete leaves an incomplete
+// backupset rather than one that looks restorable.
+func (cn *Conn) delete(uniqueid string, blobpath string) error {
+	objects, err := cn.listAllBlobs(uniqueid, blobpath)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("No matching blob found under %s in container %s", blobpath, cn.azcontainer)
+	}
+	relpaths := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		relpaths = append(relpaths, obj.Path)
+	}
+	relpaths = nzbackup.ControlLast(relpaths)
+	slices.Reverse(relpaths)
+
+	log.Printf("Deleting %d blobs under %s from container %s", len(relpaths), blobpath, cn.azcontainer)
+	for _, relpath := range relpaths {
+		blobURL, err := cn.getBlobURL(path.Join(uniqueid, filepath.ToSlash(relpath)))
+		if err != nil {
+			return err
+		}
+		_, err = blobURL.Delete(context.Background(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+		if err != nil {
+			return fmt.Errorf("Unable to delete blob %s, it may be protected by an immutability policy: %v", relpath, err)
+		}
+	}
+	log.Println("Total blobs deleted:", len(relpaths))
+	return nil
+}
+
+// copy copies every blob stored under blobpath to the unique id copyTo within
+// the container, without downloading it. The commit blob of a backupset is
+// copied last.
+func (cn *Conn) copy(uniqueid string, blobpath string, copyTo string, paralleljobs int) error {
+	objects, err := cn.listAllBlobs(uniqueid, blobpath)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("No matching blob found under %s in container %s", blobpath, cn.azcontainer)
+	}
+	var files, control []string
+	for _, obj := range objects {
+		if nzbackup.IsControlPath(obj.Path) {
+			control = append(control, obj.Path)
+		} else {
+			files = append(files, obj.Path)
+		}
+	}
+	log.Printf("Copying %d blobs under %s to uniqueid %s", len(objects), blobpath, copyTo)
+
+	work := make(chan string)
+	result := make(chan error)
+	for i := 0; i < paralleljobs; i++ {
+		go func() {
+			for relpath := range work {
+				result <- cn.copyBlob(uniqueid, copyTo, relpath)
+			}
+		}()
+	}
+	go func() {
+		for _, relpath := range files {
+			work <- relpath
+		}
+		close(work)
+	}()
+	for range files {
+		if err := <-result; err != nil {
+			// stopping right here so that we don't keep on copying when one has failed
+			log.Fatalln("Error:", err)
+		}
+	}
+	for _, relpath := range nzbackup.ControlLast(control) {
+		if err := cn.copyBlob(uniqueid, copyTo, relpath); err != nil {
+			return err
+		}
+	}
+	log.Println("Total blobs copied:", len(objects))
+	return nil
+}
+
+// copyBlob copies a blob within the container and waits for the copy to finish.
+func (cn *Conn) copyBlob(uniqueid string, copyTo string, relpath string) error {
+	src, err := cn.getBlobURL(path.Join(uniqueid, filepath.ToSlash(relpath)))
+	if err != nil {
+		return err
+	}
+	dst, err := cn.getBlobURL(path.Join(copyTo, filepath.ToSlash(relpath)))
+	if err != nil {
+		return err
+	}
+	resp, err := dst.StartCopyFromURL(context.Background(), src.URL(), nil, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to copy blob %s: %v", relpath, err)
+	}
+	status := resp.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		time.Sleep(2 * time.Second)
+		props, err := dst.GetProperties(context.Background(), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return fmt.Errorf("Unable to read copy status of blob %s: %v", relpath, err)
+		}
+		status = props.CopyStatus()
+		if status != azblob.CopyStatusSuccess && status != azblob.CopyStatusPending {
+			return fmt.Errorf("Copy of blob %s ended with status %s: %s", relpath, status, props.CopyStatusDescription())
+		}
+	}
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("Copy of blob %s ended with status %s", relpath, status)
+	}
+	return nil
+}
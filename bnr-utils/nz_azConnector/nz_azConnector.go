@@ -5,45 +5,97 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
 )
 
 type Conn struct {
-	azaccount   string
-	azkey       string
-	azcontainer string
-	streams     uint
-	blocksize   int64
+	azaccount        string
+	azkey            string
+	azcontainer      string
+	streams          uint
+	blocksize        int64
+	minBlocksize     int64
+	maxBlocksize     int64
+	immutabilityMode string
+	retainDays       int
+	retainUntil      string
+	retainUntilDate  *time.Time
+	legalHold        bool
+	blobTags         bool
+	http2            bool
+	// serviceURL is shared by every request of the run once connected, so
+	// that connections are reused
+	serviceURL *azblob.ServiceURL
+	metrics    *nzbackup.ConnMetrics
 }
 
 type BackupInfo struct {
-	dbname      string
-	dirs        string
-	npshost     string
-	backupsetID string
+	dbname        string
+	dirs          string
+	npshost       string
+	backupsetID   string
+	restoreAsHost string
+	restoreAsDb   string
+	increment     int
+	asOf          string
+	tables        string
+	before        string
 }
 
 type OtherArgs struct {
-	uniqueid     string
-	logfiledir   string
-	upload       *bool
-	download     *bool
-	paralleljobs int
+	uniqueid          string
+	logfiledir        string
+	upload            *bool
+	download          *bool
+	paralleljobs      int
+	rehydrate         *bool
+	rehydrateStatus   *bool
+	waitRehydrate     *bool
+	rehydrateTier     string
+	rehydratePriority string
+	pollInterval      int
+	requireImmutable  *bool
+	allowIncomplete   *bool
+	checksum          *bool
+	inspect           *bool
+	diff              string
+	listLocal         *bool
+	skipValidation    *bool
+	stdinPath         string
+	fifo              *bool
+	list              *bool
+	hierarchical      *bool
+	verify            *bool
+	delete            *bool
+	copyTo            string
+	configPath        string
+	profile           string
+	showConfig        *bool
+	check             *bool
+	bench             *bool
+	benchMatrix       string
+	benchSize         int64
 }
 
 type job struct {
 	conn     Conn
 	uniqueid string
 	bkpdir   string
+	// location number of bkpdir in a backup striped across several directories
+	location int
 }
 
 type uploadJob struct {
@@ -53,17 +105,38 @@ type uploadJob struct {
 
 type jobResult struct {
 	*job
-	err error
+	relfilepath string
+	file        nzbackup.ManifestFile
+	err         error
 }
 
-func (j *uploadJob) upload() error {
+// upload uploads the file and returns its path relative to the backup
+// directory and its description for the backupset manifest.
+func (j *uploadJob) upload(checksum bool) (string, nzbackup.ManifestFile, error) {
 	relfilepath, err := filepath.Rel(j.job.bkpdir, j.absfilepath)
 	if err != nil {
-		return fmt.Errorf("Unable to traverse %s, %s: %v", j.job.bkpdir, j.absfilepath, err)
+		return "", nzbackup.ManifestFile{}, fmt.Errorf("Unable to traverse %s, %s: %v", j.job.bkpdir, j.absfilepath, err)
+	}
+
+	relfilepath = nzbackup.LocationPath(relfilepath, j.job.location)
+	info, err := os.Stat(j.absfilepath)
+	if err != nil {
+		return "", nzbackup.ManifestFile{}, fmt.Errorf("Error in reading backup file on file system: %v", err)
+	}
+	// named pipes written by nzbackup are uploaded while they are written
+	if nzbackup.IsStream(info) {
+		log.Println("Uploading stream :", j.absfilepath)
+		file, err := j.job.conn.uploadStreamFile(j.absfilepath, info.Mode(), relfilepath, j.job.uniqueid, checksum)
+		return relfilepath, file, err
+	}
+
+	file, err := nzbackup.DescribeFile(j.absfilepath, checksum)
+	if err != nil {
+		return "", file, fmt.Errorf("Error in reading backup file on file system: %v", err)
 	}
 
 	log.Println("Uploading file :", j.absfilepath)
-	return j.job.conn.uploadFile(j.absfilepath, relfilepath, j.job.uniqueid, j.job.conn.streams, j.job.conn.blocksize)
+	return relfilepath, file, j.job.conn.uploadFile(j.absfilepath, relfilepath, j.job.uniqueid, j.job.conn.streams, j.job.conn.blocksize)
 }
 
 type downloadJob struct {
@@ -103,19 +176,61 @@ func parseArgs(conn *Conn, backupinfo *BackupInfo, othargs *OtherArgs) {
 	flag.StringVar(&backupinfo.dbname, "db", "", "Database name")
 	flag.StringVar(&backupinfo.dirs, "dir", "", "Full path to the directory in which the backup already exists or should be downloaded")
 	flag.StringVar(&backupinfo.npshost, "npshost", "", "Name of the NPS host as it appears in the backups")
-	flag.StringVar(&backupinfo.backupsetID, "backupset", "", "Name of the backupset to be uploaded/downloaded")
+	flag.StringVar(&backupinfo.backupsetID, "backupset", "", "Name of the backupset to be uploaded/downloaded. Use latest for the newest backupset in the cloud")
+	flag.StringVar(&backupinfo.before, "before", "", "Use the newest backupset started at or before this time and its increments completed by then (YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss or RFC3339)")
+	flag.StringVar(&backupinfo.restoreAsHost, "restore-as-host", "", "NPS host name the downloaded backup is restored under, if different from -npshost")
+	flag.StringVar(&backupinfo.restoreAsDb, "restore-as-db", "", "Database name the downloaded backup is restored under, if different from -db")
+	flag.IntVar(&backupinfo.increment, "increment", 0, "Download only the increments needed to restore the backupset up to this increment")
+	flag.StringVar(&backupinfo.asOf, "as-of", "", "Download only the increments needed to restore the backupset as of this time (YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss or RFC3339)")
+	flag.StringVar(&backupinfo.tables, "tables", "", "Download only the data of these tables, a comma separated list of db.schema.table that may contain globs")
 
 	flag.StringVar(&conn.azaccount, "storage-account", "", "Azure blob storage account")
 	flag.StringVar(&conn.azkey, "key", "", "Azure blob storage access key")
 	flag.StringVar(&conn.azcontainer, "container", "", "Azure blob storage container")
 	flag.UintVar(&conn.streams, "streams", 16, "Number of blocks to upload/download in parallel")
-	flag.Int64Var(&conn.blocksize, "blocksize", 100, "Block size in MB to upload/download file")
+	flag.Int64Var(&conn.blocksize, "blocksize", 100, "Block size in MB to upload/download file. Uploads of files too large for 50,000 blocks of this size use larger blocks")
+	flag.Int64Var(&conn.minBlocksize, "min-blocksize", 0, "Smallest block size in MB uploads may use")
+	flag.Int64Var(&conn.maxBlocksize, "max-blocksize", 0, "Largest block size in MB uploads may use. Default is the Azure maximum of 4000 MB")
+	flag.StringVar(&conn.immutabilityMode, "immutability-mode", "", "Immutability policy mode for uploaded blobs: Unlocked or Locked")
+	flag.IntVar(&conn.retainDays, "retain-days", 0, "Number of days uploaded blobs are protected against deletion and overwrite")
+	flag.StringVar(&conn.retainUntil, "retain-until", "", "Date (YYYY-MM-DD or RFC3339) until which uploaded blobs are protected")
+	flag.BoolVar(&conn.legalHold, "legal-hold", false, "Place a legal hold on uploaded blobs")
+	flag.BoolVar(&conn.blobTags, "tags", false, "Also add the backup description stored in the blob metadata as blob index tags")
+	flag.BoolVar(&conn.http2, "http2", false, "Use HTTP/2 when the endpoint supports it instead of a connection per stream")
 
 	flag.StringVar(&othargs.uniqueid, "uniqueid", "", "Azure blob storage container")
 	flag.StringVar(&othargs.logfiledir, "logfiledir", "/tmp", "Logfile directory for this utility. Default is /tmp dir")
 	othargs.upload = flag.Bool("upload", false, "Upload to cloud")
 	othargs.download = flag.Bool("download", false, "Download from cloud")
+	othargs.listLocal = flag.Bool("list-local", false, "List the hosts, databases and backupsets found in the local backup directories")
+	othargs.skipValidation = flag.Bool("skip-validation", false, "Upload without checking that the local backup is complete nzbackup output")
+	flag.StringVar(&othargs.stdinPath, "stdin", "", "With -upload, upload the standard input as this file path relative to the backupset directory")
+	othargs.fifo = flag.Bool("fifo", false, "With -download, stream the table data files into named pipes for nzrestore instead of writing them to disk")
 	flag.IntVar(&othargs.paralleljobs, "paralleljobs", 6, "Number of parallel files to upload/download")
+
+	othargs.inspect = flag.Bool("inspect", false, "Report the increments, tables and data file sizes of the backupset by reading only its md files")
+	flag.StringVar(&othargs.diff, "diff", "", "Report tables added, dropped or changed in size between two backupsets, given as A,B")
+	othargs.list = flag.Bool("list", false, "List the backupsets stored under the uniqueid with their file count, size and commit state")
+	othargs.hierarchical = flag.Bool("hierarchical", false, "With -list, find the backupsets level by level with hierarchical listings and list them -paralleljobs at a time")
+	othargs.verify = flag.Bool("verify", false, "Check the backupset in the container against its commit blob and manifest without downloading it")
+	othargs.delete = flag.Bool("delete", false, "Delete every blob of the backupset from the container")
+	flag.StringVar(&othargs.copyTo, "copy-to", "", "Copy the backupset to this uniqueid within the container without downloading it")
+	othargs.rehydrate = flag.Bool("rehydrate", false, "Rehydrate blobs of the backupset that are in the archive tier")
+	othargs.rehydrateStatus = flag.Bool("rehydrate-status", false, "Report how many blobs of the backupset are still in the archive tier")
+	othargs.waitRehydrate = flag.Bool("wait-rehydrate", false, "Wait until all blobs of the backupset are rehydrated before downloading")
+	flag.StringVar(&othargs.rehydrateTier, "rehydrate-tier", "Hot", "Access tier to rehydrate archived blobs to: Hot or Cool")
+	flag.StringVar(&othargs.rehydratePriority, "rehydrate-priority", "Standard", "Rehydrate priority for archived blobs: Standard or High")
+	flag.IntVar(&othargs.pollInterval, "poll-interval", 15, "Minutes to wait between checks while waiting for blobs to be rehydrated")
+	othargs.checksum = flag.Bool("checksum", false, "Record SHA-256 checksums in the backupset manifest on upload and verify them on download. Reads every file a second time")
+	othargs.allowIncomplete = flag.Bool("allow-incomplete", false, "Download backupsets without a commit object or with missing files, only logging a warning")
+	othargs.requireImmutable = flag.Bool("require-immutable", false, "Refuse to upload unless immutable storage is enabled on the container")
+	flag.StringVar(&othargs.configPath, "config", "", "Configuration file with named profiles. Default is ~/"+nzbackup.ConfigFileName)
+	flag.StringVar(&othargs.profile, "profile", "", "Profile of the configuration file supplying the flags not given on the command line. Default is the default profile, if any")
+	othargs.check = flag.Bool("check", false, "Check the credentials, storage account, container and the list, put, get, block upload and delete permissions with a probe blob, and report the step that failed")
+	othargs.bench = flag.Bool("bench", false, "Upload and download synthetic data under a scratch prefix with every setting of -bench-matrix, report the throughput of each and recommend the fastest")
+	flag.StringVar(&othargs.benchMatrix, "bench-matrix", nzbackup.DefaultBenchMatrix, "Settings tried by -bench, as streams=N,N blocksize=N,N paralleljobs=N,N")
+	flag.Int64Var(&othargs.benchSize, "bench-size", 64, "Size in MB of each synthetic file transferred by -bench")
+	othargs.showConfig = flag.Bool("show-config", false, "Print the effective value and source of every flag, with secrets masked, and exit")
 }
 
 func handleErrors(err error) {
@@ -124,7 +239,42 @@ func handleErrors(err error) {
 	}
 }
 
+// connect creates the pipeline shared by every request of the run, with a
+// connection pool sized for paralleljobs files of streams blocks each.
+func (cn *Conn) connect(paralleljobs int) error {
+	cn.metrics = new(nzbackup.ConnMetrics)
+	client := nzbackup.NewHTTPClient(paralleljobs*int(cn.streams), cn.http2, cn.metrics)
+	serviceURL, err := cn.newServiceURL(httpSender(client))
+	if err != nil {
+		return err
+	}
+	cn.serviceURL = &serviceURL
+	return nil
+}
+
+// httpSender sends the requests of a pipeline with client.
+func httpSender(client *http.Client) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			r, err := client.Do(request.WithContext(ctx))
+			if err != nil {
+				err = pipeline.NewError(err, "HTTP request failed")
+			}
+			return pipeline.NewHTTPResponse(r), err
+		}
+	})
+}
+
 func (cn *Conn) getServiceURL() (azblob.ServiceURL, error) {
+	if cn.serviceURL != nil {
+		return *cn.serviceURL, nil
+	}
+	return cn.newServiceURL(nil)
+}
+
+// newServiceURL creates a pipeline sending with sender, or with the default
+// sender of azblob when sender is nil.
+func (cn *Conn) newServiceURL(sender pipeline.Factory) (azblob.ServiceURL, error) {
 	var serviceURL azblob.ServiceURL
 	us := fmt.Sprintf("https://%s.blob.core.windows.net/", cn.azaccount)
 	u, err := url.Parse(us)
@@ -137,11 +287,13 @@ func (cn *Conn) getServiceURL() (azblob.ServiceURL, error) {
 		return serviceURL, fmt.Errorf("Unable to create shared credentials. Ensure azure storage account name:%s and azure key are correct.\n Error details: %v", cn.azaccount, err)
 	}
 
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{
+	options := azblob.PipelineOptions{
 		Retry: azblob.RetryOptions{
 			TryTimeout: 5 * time.Minute,
 		},
-	})
+		HTTPSender: sender,
+	}
+	p := azblob.NewPipeline(credential, options)
 
 	serviceURL = azblob.NewServiceURL(*u, p)
 	return serviceURL, nil
@@ -185,16 +337,105 @@ func (cn *Conn) uploadFile(absfilepath string, relfilepath string, uniqueid stri
 	if err != nil {
 		return fmt.Errorf("Error in opening backup file on file system: %v", err)
 	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("Error in reading backup file on file system: %v", err)
+	}
+	// files up to 256 MB are uploaded with a single request whatever the block size
+	blockSize, err = cn.blockSizeFor(info.Size(), blockSize)
+	if err != nil {
+		return fmt.Errorf("Cannot upload %s: %v", absfilepath, err)
+	}
 
-	_, err = azblob.UploadFileToBlockBlob(context.Background(), file, blockBlobURL,
-		azblob.UploadToBlockBlobOptions{
-			BlockSize:   int64(blockSize * 1024 * 1024),
-			Parallelism: uint16(streams),
-		})
+	_, err = azblob.UploadFileToBlockBlob(context.Background(), file, blockBlobURL, cn.uploadOptions(relfilepath, streams, blockSize))
 
 	return err
 }
 
+// blockSizeFor returns the block size in MB to upload a blob of size bytes,
+// or of unknown size when size is below 0, starting from the preferred size
+// in MB, within the block count limit of a block blob.
+func (cn *Conn) blockSizeFor(size int64, preferred int64) (int64, error) {
+	part, err := nzbackup.AzureLimits.PartSize(size, preferred*1024*1024, cn.minBlocksize*1024*1024, cn.maxBlocksize*1024*1024)
+	return part / (1024 * 1024), err
+}
+
+// uploadOptions describes the upload of a backup file with the metadata, tags
+// and immutability policy requested for this run.
+func (cn *Conn) uploadOptions(relfilepath string, streams uint, blockSize int64) azblob.UploadToBlockBlobOptions {
+	metadata := nzbackup.ObjectMetadata(relfilepath, time.Now())
+	var tags azblob.BlobTagsMap
+	if cn.blobTags {
+		tags = azblob.BlobTagsMap(metadata)
+	}
+
+	return azblob.UploadToBlockBlobOptions{
+		BlockSize:                 int64(blockSize * 1024 * 1024),
+		Parallelism:               uint16(streams),
+		Metadata:                  azblob.Metadata(metadata),
+		BlobTagsMap:               tags,
+		ImmutabilityPolicyOptions: cn.immutabilityPolicyOptions(),
+	}
+}
+
+// uploadStreamFile uploads a named pipe as it is written and returns its
+// manifest entry once the writer has closed it.
+func (cn *Conn) uploadStreamFile(absfilepath string, mode os.FileMode, relfilepath string, uniqueid string, checksum bool) (nzbackup.ManifestFile, error) {
+	// opening blocks until nzbackup opens the pipe for writing
+	file, err := os.Open(absfilepath)
+	if err != nil {
+		return nzbackup.ManifestFile{}, fmt.Errorf("Error in opening backup stream on file system: %v", err)
+	}
+	defer file.Close()
+	return cn.uploadStream(file, mode, relfilepath, uniqueid, checksum)
+}
+
+// uploadStream uploads a reader of unknown length as blocks of the block
+// size, holding at most streams blocks in memory.
+func (cn *Conn) uploadStream(r io.Reader, mode os.FileMode, relfilepath string, uniqueid string, checksum bool) (nzbackup.ManifestFile, error) {
+	blockBlobURL, err := cn.getBlockBlobURL(uniqueid + "/" + relfilepath)
+	if err != nil {
+		return nzbackup.ManifestFile{}, err
+	}
+
+	blockSize, err := cn.blockSizeFor(-1, cn.blocksize)
+	if err != nil {
+		return nzbackup.ManifestFile{}, err
+	}
+	opts := cn.uploadOptions(relfilepath, cn.streams, blockSize)
+	stream := nzbackup.NewStreamReader(r, mode, checksum)
+	_, err = azblob.UploadStreamToBlockBlob(context.Background(), stream, blockBlobURL,
+		azblob.UploadStreamToBlockBlobOptions{
+			BufferSize:                int(opts.BlockSize),
+			MaxBuffers:                int(opts.Parallelism),
+			Metadata:                  opts.Metadata,
+			BlobTagsMap:               opts.BlobTagsMap,
+			ImmutabilityPolicyOptions: opts.ImmutabilityPolicyOptions,
+		})
+	if err != nil {
+		return nzbackup.ManifestFile{}, fmt.Errorf("Error in uploading stream to an Azure blob: %v", err)
+	}
+	return stream.File(), nil
+}
+
+// uploadStdin uploads the standard input as a single file of the backupset,
+// for nzbackup output piped into the connector. No commit blob is written,
+// as the connector cannot know when the backupset is complete.
+func (cn *Conn) uploadStdin(backupinfo BackupInfo, othargs OtherArgs) error {
+	relfilepath := path.Join("Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID, filepath.ToSlash(othargs.stdinPath))
+	log.Printf("Uploading standard input to container %s as %s/%s", cn.azcontainer, othargs.uniqueid, relfilepath)
+	file, err := cn.uploadStream(os.Stdin, 0644, relfilepath, othargs.uniqueid, *othargs.checksum)
+	if err != nil {
+		return err
+	}
+	log.Printf("Standard input uploaded successfully, %d bytes", file.Size)
+	if file.SHA256 != "" {
+		log.Printf("SHA-256 checksum: %s", file.SHA256)
+	}
+	return nil
+}
+
 func (cn *Conn) downloadFile(outfilepath string, blobname string, streams uint, blockSize int64) error {
 
 	filehandle, err := os.Create(outfilepath)
@@ -222,29 +463,71 @@ func (cn *Conn) downloadFile(outfilepath string, blobname string, streams uint,
 	return err
 }
 
-func (cn *Conn) downloadBkp(outdir string, uniqueid string, blobpath string, streams uint, paralleljobs int) error {
-	var err error
-	locations := []string{}
-	contents := []string{}
+func (cn *Conn) downloadBkp(outdirs []string, uniqueid string, blobpath string, rename nzbackup.Rename, restorePoint nzbackup.RestorePoint, tables string, streams uint, paralleljobs int, othargs OtherArgs) error {
+	blobnames, modtimes, manifest, err := cn.selectBackupBlobs(uniqueid, blobpath, *othargs.allowIncomplete)
+	if err != nil {
+		return err
+	}
+
+	relpaths := make([]string, 0, len(blobnames))
+	for _, blobname := range blobnames {
+		relpath, err := filepath.Rel(uniqueid, blobname)
+		if err != nil {
+			return fmt.Errorf("Error in fetching download relative path: %v", err)
+		}
+		relpaths = append(relpaths, relpath)
+	}
+	if !restorePoint.IsZero() {
+		blobnames, relpaths, err = selectRestorePoint(restorePoint, blobnames, relpaths, modtimes)
+		if err != nil {
+			return err
+		}
+	}
+	if tables != "" {
+		blobnames, relpaths, err = cn.selectTables(tables, uniqueid, blobnames, relpaths)
+		if err != nil {
+			return err
+		}
+	}
+	// every location of the backup is restored into its own directory
+	placement, err := nzbackup.NewPlacement(outdirs, relpaths, rename)
+	if err != nil {
+		return err
+	}
+	if placement.Locations() != len(outdirs) {
+		log.Printf("Backup has %d locations, restoring them into %d directories", placement.Locations(), len(outdirs))
+	}
+	if !rename.IsZero() {
+		relbkpath, _ := filepath.Rel(uniqueid, blobpath)
+		log.Printf("Restoring backup as %s", rename.Path(relbkpath))
+	}
+
+	// local files that may need a fixup before nzrestore accepts them
+	metadatafiles := []string{}
+	// with -fifo, data files are streamed once the other files are downloaded
+	var fifos []fifoFile
+	streamed := make(map[string]bool)
 	work := make(chan *downloadJob, paralleljobs)
 	result := make(chan *downloadJobResult, paralleljobs)
 	done := make(chan bool)
 
-	// start the workers
-	go func() {
-		for {
-			select {
-			case j, ok := <-work:
-				if !ok {
-					// done
-					close(result)
-					return
-				}
+	// start the workers, one download per worker at a time
+	var workers sync.WaitGroup
+	for range max(paralleljobs, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range work {
 				err := j.download()
 				jr := downloadJobResult{blobname: j.blobname, err: err}
 				result <- &jr
 			}
-		}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		// done
+		close(result)
 	}()
 
 	filesdownloaded := 0
@@ -267,16 +550,93 @@ func (cn *Conn) downloadBkp(outdir string, uniqueid string, blobpath string, str
 		}
 	}()
 
-	blobfound := 0
+	for i, blobname := range blobnames {
+		// Set up file to download the blob to
+		outfilepath, ok := placement.LocalPath(relpaths[i])
+		if !ok {
+			log.Println("Skipping blob, a file with the same path was downloaded from a lower location :", blobname)
+			continue
+		}
 
-	for marker := (azblob.Marker{}); marker.NotDone(); {
-		containerURL, err := cn.getContainerURL()
+		err = os.MkdirAll(filepath.Dir(outfilepath), 0777)
+		if err != nil {
+			return fmt.Errorf("Error in creating backup directory structure: %v", err)
+		}
+
+		if nzbackup.NeedsFixup(relpaths[i], rename) {
+			metadatafiles = append(metadatafiles, outfilepath)
+		}
+		if *othargs.fifo && nzbackup.IsStreamable(relpaths[i]) {
+			fifos = append(fifos, fifoFile{blobname: blobname, relpath: relpaths[i], localpath: outfilepath})
+			streamed[outfilepath] = true
+			continue
+		}
+
+		j := downloadJob{conn: *cn, outfilepath: outfilepath, blobname: blobname}
+		work <- &j
+	}
+	close(work)
+	<-done
+	log.Println("Total files downloaded:", filesdownloaded)
+	if manifest != nil {
+		relbkpath, err := filepath.Rel(uniqueid, blobpath)
+		if err != nil {
+			return fmt.Errorf("Error in fetching download relative path: %v", err)
+		}
+		err = verifyDownload(manifest, func(p string) (string, bool) {
+			local, ok := placement.LocalPath(path.Join(relbkpath, p))
+			return local, ok && !streamed[local]
+		}, *othargs.checksum)
 		if err != nil {
 			return err
 		}
+	}
+	warnings, err := nzbackup.FixupRestore(metadatafiles, placement)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		log.Println("WARNING:", warning)
+	}
+	if len(fifos) == 0 {
+		return nil
+	}
+	return cn.serveFifos(fifos, manifest, *othargs.checksum)
+}
 
+// selectRestorePoint keeps the blobs of the increments needed to restore up to the restore point.
+func selectRestorePoint(restorePoint nzbackup.RestorePoint, blobnames []string, relpaths []string, modtimes map[string]time.Time) ([]string, []string, error) {
+	files := make(map[string]time.Time, len(blobnames))
+	for i, blobname := range blobnames {
+		files[relpaths[i]] = modtimes[blobname]
+	}
+	chain, err := restorePoint.Chain(files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to select increments to download: %v", err)
+	}
+	log.Println("Downloading increments", chain)
+
+	var selectedBlobs, selectedRelpaths []string
+	for i, relpath := range relpaths {
+		if nzbackup.InChain(relpath, chain) {
+			selectedBlobs = append(selectedBlobs, blobnames[i])
+			selectedRelpaths = append(selectedRelpaths, relpath)
+		}
+	}
+	return selectedBlobs, selectedRelpaths, nil
+}
+
+// listBackupBlobs calls fn for every blob stored under blobpath, the cloud path of the selected backup.
+// Only the blobs under blobpath are listed, the trailing separator keeping names sharing a prefix apart.
+func (cn *Conn) listBackupBlobs(blobpath string, fn func(blobInfo azblob.BlobItemInternal) error) error {
+	containerURL, err := cn.getContainerURL()
+	if err != nil {
+		return err
+	}
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
 		// Get a result segment starting with the blob indicated by the current Marker.
-		listBlob, err := containerURL.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{})
+		listBlob, err := containerURL.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{Prefix: blobpath + "/"})
 		if err != nil {
 			return fmt.Errorf("Unable to list segment of blobs with storage account:%s and container:%s. Ensure azure storage account and container are correct.\n Error details: %v", cn.azaccount, cn.azcontainer, err)
 		}
@@ -286,94 +646,201 @@ func (cn *Conn) downloadBkp(outdir string, uniqueid string, blobpath string, str
 		marker = listBlob.NextMarker
 		// Process the blobs returned in this result segment (if the segment is empty, the loop body won't execute)
 		for _, blobInfo := range listBlob.Segment.BlobItems {
-			if strings.HasPrefix(blobInfo.Name, blobpath) {
+			if err := fn(blobInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
 
-				// Set up file to download the blob to
-				dir, filename := filepath.Split(blobInfo.Name)
+// listSubdirs returns the directories directly under dir, from a hierarchical
+// listing that does not return the blobs below them.
+func (cn *Conn) listSubdirs(dir string) ([]string, error) {
+	containerURL, err := cn.getContainerURL()
+	if err != nil {
+		return nil, err
+	}
 
-				relfilepath, err := filepath.Rel(uniqueid, dir)
-				if err != nil {
-					return fmt.Errorf("Error in fetching download relative path: %v", err)
-				}
+	var subdirs []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		listBlob, err := containerURL.ListBlobsHierarchySegment(context.Background(), marker, "/", azblob.ListBlobsSegmentOptions{Prefix: dir + "/"})
+		if err != nil {
+			return nil, fmt.Errorf("Unable to list directories under %s with storage account:%s and container:%s.\n Error details: %v", dir, cn.azaccount, cn.azcontainer, err)
+		}
+		marker = listBlob.NextMarker
+		for _, prefix := range listBlob.Segment.BlobPrefixes {
+			subdirs = append(subdirs, strings.TrimSuffix(prefix.Name, "/"))
+		}
+	}
+	return subdirs, nil
+}
 
-				dumpdir := filepath.Join(outdir, relfilepath)
-				err = os.MkdirAll(dumpdir, 0777)
-				if err != nil {
-					return fmt.Errorf("Error in creating backup directory structure: %v", err)
-				}
+// detectLocalBackup checks -npshost, -db and -backupset against the backups
+// found in the local directories and fills in the ones that are unambiguous
+// for an upload. With -list-local, the local backups are reported.
+func detectLocalBackup(backupinfo *BackupInfo, dirlist []string, othargs OtherArgs) error {
+	if backupinfo.dirs == "" {
+		return fmt.Errorf("Missing required field: dir is not found")
+	}
+	local, err := nzbackup.ScanLocal(dirlist)
+	if err != nil {
+		return fmt.Errorf("Error reading backup directory: %v", err)
+	}
+	if *othargs.listLocal {
+		for _, line := range local.Report() {
+			log.Println(line)
+		}
+	}
+	if !*othargs.upload {
+		return nil
+	}
 
-				switch filename {
-				case "locations.txt":
-					locations = append(locations, path.Join(dumpdir, filename))
-				case "contents.txt":
-					contents = append(contents, path.Join(dumpdir, filename))
-				}
+	host, db, backupset, err := local.Resolve(backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+	if err != nil {
+		return err
+	}
+	if host != backupinfo.npshost {
+		log.Println("Detected Nps hostname :", host)
+	}
+	if db != backupinfo.dbname {
+		log.Println("Detected DB name :", db)
+	}
+	backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID = host, db, backupset
+	return nil
+}
 
-				outfilepath := path.Join(dumpdir, filename)
-				j := downloadJob{conn: *cn, outfilepath: outfilepath, blobname: blobInfo.Name}
-				work <- &j
+// validateLocalBackup refuses to upload a local backup that does not look
+// like complete nzbackup output.
+func validateLocalBackup(dirlist []string, backupinfo BackupInfo) error {
+	problems, warnings, err := nzbackup.ValidateUpload(dirlist, backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+	if err != nil {
+		return fmt.Errorf("Error reading backup directory: %v", err)
+	}
+	for _, warning := range warnings {
+		log.Println("WARNING:", warning)
+	}
+	for _, problem := range problems {
+		log.Printf("ERROR: %s", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Local backup is incomplete, %d problems found. Use -skip-validation to upload it anyway", len(problems))
+	}
+	log.Println("Local backup validated")
+	return nil
+}
 
-				blobfound--
-			}
+// resolveBackupset replaces -backupset latest or -before with the ID of the
+// newest matching backupset in the container. With -before, only the
+// increments completed by then are downloaded unless -increment or -as-of is set.
+func (cn *Conn) resolveBackupset(backupinfo *BackupInfo, othargs OtherArgs) error {
+	if *othargs.upload {
+		return fmt.Errorf("-backupset latest and -before are not valid with -upload")
+	}
+	if backupinfo.npshost == "" || backupinfo.dbname == "" {
+		return fmt.Errorf("Missing required field: db or npshost is not found. They are required to resolve the backupset")
+	}
+	var before time.Time
+	if backupinfo.before != "" {
+		if backupinfo.backupsetID != "" && backupinfo.backupsetID != nzbackup.BackupsetLatest {
+			return fmt.Errorf("Only one of -backupset and -before can be set")
 		}
-
-		if blobfound == 0 {
-			return fmt.Errorf("No matching blob found. Please check if DB name, hostname, uniqueid or containername are correct. If error persists contact IBM support team. Azaccount:%s AzContainer:%s Blobpath:%s, Uniqueid:%s", cn.azaccount, cn.azcontainer, blobpath, uniqueid)
+		var err error
+		before, err = nzbackup.ParseTimestamp(backupinfo.before)
+		if err != nil {
+			return err
 		}
 	}
-	close(work)
-	<-done
-	log.Println("Total files downloaded:", filesdownloaded)
-	updateLocation(locations, outdir)
-	updateContents(contents)
-	return err
-}
 
-func updateLocation(arrLoc []string, outdir string) {
-	for _, locFile := range arrLoc {
-		input, err := ioutil.ReadFile(locFile)
+	dbpath := path.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname)
+	var relpaths []string
+	err := cn.listBackupBlobs(dbpath, func(blobInfo azblob.BlobItemInternal) error {
+		relpath, err := filepath.Rel(othargs.uniqueid, blobInfo.Name)
 		if err != nil {
-			log.Fatalf("Unable to open %s to read: %v\n", locFile, err)
-		}
-		lines := strings.Split(string(input), "\n")
-		if len(lines) == 2 && !strings.HasSuffix(lines[len(lines)-2], outdir) {
-			f, err := os.OpenFile(locFile,
-				os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Fatalf("Unable to open %s for update: %v\n", locFile, err)
-			}
-			defer f.Close()
-			textAppend := "1,1,1," + outdir + "\n"
-			if _, err := f.WriteString(textAppend); err != nil {
-				log.Fatalf("Unable to update %s: %v\n", locFile, err)
-			}
+			return fmt.Errorf("Error in fetching relative path: %v", err)
 		}
+		relpaths = append(relpaths, relpath)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	id, err := nzbackup.ResolveBackupset(relpaths, before, *othargs.allowIncomplete)
+	if err != nil {
+		return fmt.Errorf("Unable to resolve backupset under %s: %v", dbpath, err)
 	}
+	log.Println("Resolved BackupsetID :", id)
+	backupinfo.backupsetID = id
+	if backupinfo.before != "" && backupinfo.increment == 0 && backupinfo.asOf == "" {
+		backupinfo.asOf = backupinfo.before
+	}
+	return nil
 }
 
-func updateContents(arrContents []string) {
-	for _, contentFile := range arrContents {
-		input, err := ioutil.ReadFile(contentFile)
-		if err != nil {
-			log.Fatalln("Unable to open %s to read: %v\n", contentFile, err)
+// checkDownloadArgs validates the arguments that only apply to a download.
+func checkDownloadArgs(backupinfo BackupInfo, othargs OtherArgs) error {
+	if *othargs.upload && *othargs.download {
+		return fmt.Errorf("Only one of -upload and -download can be set")
+	}
+	restorePoint := nzbackup.RestorePoint{Increment: backupinfo.increment, AsOf: backupinfo.asOf}
+	if !restorePoint.IsZero() {
+		if !*othargs.download {
+			return fmt.Errorf("-increment and -as-of are only valid with -download")
 		}
-
-		lines := strings.Split(string(input), "\n")
-		var textline []string
-		for i := 0; i < len(lines); i++ {
-			line := lines[i]
-			token := strings.Split(line, ",")
-			if token[len(token)-1] == "0" {
-				token[len(token)-1] = "1"
-			}
-			textline = append(textline, strings.Join(token, ","))
+		if backupinfo.backupsetID == "" && backupinfo.before == "" {
+			return fmt.Errorf("Missing required field: backupset is required with -increment or -as-of")
 		}
-		output := strings.Join(textline, "\n")
-		err = ioutil.WriteFile(contentFile, []byte(output), 0644)
-		if err != nil {
-			log.Fatalln("Unable to update %s: %v\n", contentFile, err)
+		if err := restorePoint.Check(); err != nil {
+			return err
 		}
 	}
+	if *othargs.fifo && !*othargs.download {
+		return fmt.Errorf("-fifo is only valid with -download")
+	}
+	if backupinfo.tables != "" && !*othargs.download {
+		return fmt.Errorf("-tables is only valid with -download")
+	}
+	if *othargs.waitRehydrate && othargs.pollInterval <= 0 {
+		return fmt.Errorf("Invalid poll interval %d. It must be a positive number of minutes", othargs.pollInterval)
+	}
+	if backupinfo.restoreAsHost == "" && backupinfo.restoreAsDb == "" {
+		return nil
+	}
+	if !*othargs.download {
+		return fmt.Errorf("-restore-as-host and -restore-as-db are only valid with -download")
+	}
+	if backupinfo.restoreAsHost != "" && backupinfo.npshost == "" {
+		return fmt.Errorf("Missing required field: npshost is required with -restore-as-host")
+	}
+	if backupinfo.restoreAsDb != "" && backupinfo.dbname == "" {
+		return fmt.Errorf("Missing required field: db is required with -restore-as-db")
+	}
+	if strings.ContainsAny(backupinfo.restoreAsHost+backupinfo.restoreAsDb, "/\\") {
+		return fmt.Errorf("Invalid -restore-as-host or -restore-as-db, names must not contain path separators")
+	}
+	return nil
+}
+
+// checkStdinArgs validates the arguments of an upload of the standard input.
+func checkStdinArgs(backupinfo BackupInfo, othargs OtherArgs) error {
+	if othargs.stdinPath == "" {
+		return nil
+	}
+	if !*othargs.upload {
+		return fmt.Errorf("-stdin is only valid with -upload")
+	}
+	if backupinfo.npshost == "" || backupinfo.dbname == "" || backupinfo.backupsetID == "" {
+		return fmt.Errorf("Missing required field: npshost, db and backupset are required with -stdin")
+	}
+	return nzbackup.CheckStreamPath(othargs.stdinPath)
+}
+
+// standardEnv are the Azure environment variables honored for the flags not
+// given on the command line or in an NZ_CONNECTOR_ variable.
+var standardEnv = map[string][]string{
+	"storage-account": {"AZURE_STORAGE_ACCOUNT"},
+	"key":             {"AZURE_STORAGE_KEY"},
 }
 
 func main() {
@@ -384,13 +851,22 @@ func main() {
 	// parse input args
 	parseArgs(&conn, &backupinfo, &othargs)
 	flag.Parse()
+	settings := nzbackup.NewSettings(flag.CommandLine, nzbackup.BackendAzure)
+	handleErrors(settings.ApplyEnv(standardEnv))
+	handleErrors(settings.ApplyProfile(othargs.configPath, othargs.profile))
+	if *othargs.showConfig {
+		for _, line := range settings.Report("key") {
+			fmt.Println(line)
+		}
+		return
+	}
 
 	// log file configuration setup
 	logfilename := fmt.Sprintf("nz_azConnector_%d_%s.log", os.Getppid(), time.Now().Format("2006-01-02"))
 	logfilepath := path.Join(othargs.logfiledir, logfilename)
 	filehandle, err := os.OpenFile(logfilepath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		fmt.Errorf("Error in opening logfile: %v", err)
+		fmt.Fprintf(os.Stderr, "Error in opening logfile: %v\n", err)
 	}
 	w := io.MultiWriter(os.Stdout, filehandle)
 	log.SetOutput(w)
@@ -398,7 +874,8 @@ func main() {
 	log.SetFlags(0)
 	log.SetPrefix(prefixStr)
 
-	if flag.NFlag() == 0 {
+	// the environment and profiles set flags too, only the command line tells a bare run
+	if len(os.Args) == 1 {
 		log.Println("No arguments passed to nz_azConnector. Below is the list of valid args: ")
 		flag.PrintDefaults()
 		os.Exit(1)
@@ -425,8 +902,93 @@ func main() {
 	log.Println("UniqueID :", othargs.uniqueid)
 	log.Println("Number of files to upload/download in parallel :", othargs.paralleljobs)
 
-	for _, bkpdir := range dirlist {
-		if *othargs.upload {
+	handleErrors(checkDownloadArgs(backupinfo, othargs))
+	handleErrors(checkStdinArgs(backupinfo, othargs))
+	if *othargs.check {
+		handleErrors(conn.check(othargs.uniqueid))
+	}
+	handleErrors(conn.connect(othargs.paralleljobs))
+	if *othargs.bench {
+		handleErrors(conn.bench(backupinfo.dirs, othargs))
+	}
+	// with -stdin, the backup directories are not uploaded
+	uploadDirs := *othargs.upload && othargs.stdinPath == ""
+	if *othargs.listLocal || uploadDirs {
+		handleErrors(detectLocalBackup(&backupinfo, dirlist, othargs))
+	}
+	if backupinfo.backupsetID == nzbackup.BackupsetLatest || backupinfo.before != "" {
+		handleErrors(conn.resolveBackupset(&backupinfo, othargs))
+	}
+
+	if *othargs.inspect {
+		if backupinfo.backupsetID == "" {
+			handleErrors(fmt.Errorf("Missing required field: backupset is required with -inspect"))
+		}
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		handleErrors(conn.inspect(othargs.uniqueid, blobpath))
+	}
+
+	if *othargs.list {
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		handleErrors(conn.list(othargs.uniqueid, blobpath, *othargs.hierarchical, othargs.paralleljobs))
+	}
+
+	if *othargs.verify || *othargs.delete || othargs.copyTo != "" {
+		if backupinfo.npshost == "" || backupinfo.dbname == "" || backupinfo.backupsetID == "" {
+			handleErrors(fmt.Errorf("Missing required field: npshost, db and backupset are required with -verify, -delete and -copy-to"))
+		}
+		if othargs.copyTo != "" && othargs.copyTo == othargs.uniqueid {
+			handleErrors(fmt.Errorf("-copy-to must name a uniqueid other than -uniqueid"))
+		}
+	}
+	if *othargs.verify {
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		handleErrors(conn.verify(othargs.uniqueid, blobpath))
+	}
+
+	if othargs.diff != "" {
+		if backupinfo.npshost == "" || backupinfo.dbname == "" {
+			handleErrors(fmt.Errorf("Missing required field: db or npshost is not found. They are required with -diff"))
+		}
+		dbpath := path.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname)
+		handleErrors(conn.diff(othargs.uniqueid, dbpath, othargs.diff))
+	}
+
+	if *othargs.rehydrate || *othargs.rehydrateStatus || *othargs.waitRehydrate {
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		if *othargs.rehydrate {
+			handleErrors(conn.rehydrate(blobpath, othargs))
+			log.Println("Rehydration requested")
+		}
+		if *othargs.rehydrateStatus {
+			handleErrors(conn.rehydrateStatus(blobpath))
+		}
+		if *othargs.waitRehydrate {
+			handleErrors(conn.waitForRehydration(blobpath, othargs.pollInterval))
+		}
+	}
+
+	if *othargs.upload {
+		handleErrors(conn.prepareImmutability(othargs))
+	}
+	if *othargs.upload && othargs.stdinPath != "" {
+		handleErrors(conn.uploadStdin(backupinfo, othargs))
+		log.Println("Upload successful")
+	}
+	if uploadDirs && !*othargs.skipValidation {
+		handleErrors(validateLocalBackup(dirlist, backupinfo))
+	}
+	if uploadDirs {
+		// a file too large for the block limits fails here rather than after hours of uploading
+		handleErrors(nzbackup.AzureLimits.CheckPartSizes(dirlist, conn.blocksize*1024*1024, conn.minBlocksize*1024*1024, conn.maxBlocksize*1024*1024))
+	}
+
+	// files are collected to write the commit object of every backupset once all of them are uploaded
+	var uploaded nzbackup.UploadSet
+	for i, bkpdir := range dirlist {
+		// location number of this directory in a backup striped across several directories
+		location := i + 1
+		if uploadDirs {
 
 			// now do the upload
 			log.Println("Uploading backup data to azure cloud from backup dir", bkpdir)
@@ -440,20 +1002,25 @@ func main() {
 			result := make(chan *jobResult, othargs.paralleljobs)
 			done := make(chan bool)
 
-			go func() {
-				for {
-					select {
-					case j, ok := <-work:
-						if !ok {
-							// done
-							close(result)
-							return
-						}
-						err := j.upload()
-						jr := jobResult{job: &j.job, err: err}
+			// start the workers, one upload per worker at a time. Every file in
+			// flight needs its own worker: with named pipes nzbackup writes
+			// several files at once and blocks on any that is not being read.
+			var workers sync.WaitGroup
+			for range max(othargs.paralleljobs, 1) {
+				workers.Add(1)
+				go func() {
+					defer workers.Done()
+					for j := range work {
+						relfilepath, file, err := j.upload(*othargs.checksum)
+						jr := jobResult{job: &j.job, relfilepath: relfilepath, file: file, err: err}
 						result <- &jr
 					}
-				}
+				}()
+			}
+			go func() {
+				workers.Wait()
+				// done
+				close(result)
 			}()
 
 			filesuploaded := 0
@@ -472,6 +1039,7 @@ func main() {
 							log.Println("Error while uploading file. Ensure azure storage account name, azure key and container name are correct. If error persists contact IBM support team.", *r.job)
 							log.Fatalf("Azure storage account:%s accessing container:%s failed with error: %v", r.job.conn.azaccount, r.job.conn.azcontainer, r.err)
 						}
+						uploaded.Add(r.relfilepath, r.file)
 						filesuploaded++ // this is fine, since this is single threaded increment
 					}
 				}
@@ -479,13 +1047,16 @@ func main() {
 
 			err = filepath.Walk(backupdir,
 				func(absfilepath string, info os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
 					if info.IsDir() {
 						return nil
 					}
-					j := uploadJob{job: job{conn, othargs.uniqueid, bkpdir}, absfilepath: absfilepath}
+					j := uploadJob{job: job{conn, othargs.uniqueid, bkpdir, location}, absfilepath: absfilepath}
 					work <- &j // this will hang until at least one of the prior uploads finish if other.paralleljobs
 					// are already running
-					return err
+					return nil
 				})
 			close(work)
 			<-done
@@ -495,12 +1066,33 @@ func main() {
 			log.Println("Upload successful. Total files uploaded:", filesuploaded)
 		}
 
-		if *othargs.download {
-			log.Println("Downloading backup data from azure cloud to restore dir", bkpdir)
-			blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
-			err = conn.downloadBkp(bkpdir, othargs.uniqueid, blobpath, conn.streams, othargs.paralleljobs)
-			handleErrors(err)
-			log.Println("Download successful")
-		}
+	}
+
+	if uploadDirs {
+		handleErrors(conn.uploadCommits(othargs.uniqueid, &uploaded))
+	}
+
+	if *othargs.download {
+		log.Println("Downloading backup data from azure cloud to restore dirs", dirlist)
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		rename := nzbackup.Rename{NpsHost: backupinfo.restoreAsHost, Database: backupinfo.restoreAsDb}
+		restorePoint := nzbackup.RestorePoint{Increment: backupinfo.increment, AsOf: backupinfo.asOf}
+		err = conn.downloadBkp(dirlist, othargs.uniqueid, blobpath, rename, restorePoint, backupinfo.tables, conn.streams, othargs.paralleljobs, othargs)
+		handleErrors(err)
+		log.Println("Download successful")
+	}
+
+	if othargs.copyTo != "" {
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		handleErrors(conn.copy(othargs.uniqueid, blobpath, othargs.copyTo, othargs.paralleljobs))
+		log.Println("Copy successful")
+	}
+	if *othargs.delete {
+		blobpath := filepath.Join(othargs.uniqueid, "Netezza", backupinfo.npshost, backupinfo.dbname, backupinfo.backupsetID)
+		handleErrors(conn.delete(othargs.uniqueid, blobpath))
+		log.Println("Delete successful")
+	}
+	if conn.metrics.Requests() > 0 {
+		log.Println("Connections:", conn.metrics)
 	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+type rehydrateCounts struct {
+	readable    int
+	archived    int
+	rehydrating int
+}
+
+func (c rehydrateCounts) pending() int {
+	return c.archived + c.rehydrating
+}
+
+func (c *rehydrateCounts) add(blobInfo azblob.BlobItemInternal) {
+	switch {
+	case blobInfo.Properties.AccessTier != azblob.AccessTierArchive:
+		c.readable++
+	case blobInfo.Properties.ArchiveStatus != azblob.ArchiveStatusNone:
+		// rehydration to hot or cool is pending
+		c.rehydrating++
+	default:
+		c.archived++
+	}
+}
+
+func (cn *Conn) getRehydrateCounts(blobpath string) (rehydrateCounts, error) {
+	var counts rehydrateCounts
+	err := cn.listBackupBlobs(blobpath, func(blobInfo azblob.BlobItemInternal) error {
+		counts.add(blobInfo)
+		return nil
+	})
+	return counts, err
+}
+
+// rehydrate moves every archived blob under blobpath back to an online tier.
+func (cn *Conn) rehydrate(blobpath string, othargs OtherArgs) error {
+	tier := azblob.AccessTierType(othargs.rehydrateTier)
+	if tier != azblob.AccessTierHot && tier != azblob.AccessTierCool {
+		return fmt.Errorf("Invalid rehydrate tier %s. Valid values are Hot and Cool", othargs.rehydrateTier)
+	}
+	priority := azblob.RehydratePriorityType(othargs.rehydratePriority)
+	if priority != azblob.RehydratePriorityStandard && priority != azblob.RehydratePriorityHigh {
+		return fmt.Errorf("Invalid rehydrate priority %s. Valid values are Standard and High", othargs.rehydratePriority)
+	}
+
+	log.Printf("Rehydrating archived blobs under %s to tier %s with priority %s", blobpath, tier, priority)
+	var counts rehydrateCounts
+	err := cn.listBackupBlobs(blobpath, func(blobInfo azblob.BlobItemInternal) error {
+		if blobInfo.Properties.AccessTier == azblob.AccessTierArchive && blobInfo.Properties.ArchiveStatus == azblob.ArchiveStatusNone {
+			blobURL, err := cn.getBlobURL(blobInfo.Name)
+			if err != nil {
+				return err
+			}
+			_, err = blobURL.SetTier(context.Background(), tier, azblob.LeaseAccessConditions{}, priority)
+			if err != nil {
+				return fmt.Errorf("Unable to rehydrate blob %s: %v", blobInfo.Name, err)
+			}
+			log.Println("Rehydration requested for blob :", blobInfo.Name)
+			counts.rehydrating++
+			return nil
+		}
+		counts.add(blobInfo)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Blobs already readable: %d, rehydration in progress: %d", counts.readable, counts.rehydrating)
+	return nil
+}
+
+// rehydrateStatus reports how many blobs under blobpath are not readable yet.
+func (cn *Conn) rehydrateStatus(blobpath string) error {
+	counts, err := cn.getRehydrateCounts(blobpath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Blobs readable: %d, rehydration in progress: %d, archived without rehydration request: %d", counts.readable, counts.rehydrating, counts.archived)
+	log.Println("Blobs pending :", counts.pending())
+	return nil
+}
+
+// waitForRehydration polls the blobs under blobpath until none of them is in the archive tier.
+func (cn *Conn) waitForRehydration(blobpath string, pollInterval int) error {
+	for {
+		counts, err := cn.getRehydrateCounts(blobpath)
+		if err != nil {
+			return err
+		}
+		if counts.pending() == 0 {
+			log.Printf("All %d blobs are readable", counts.readable)
+			return nil
+		}
+		if counts.archived > 0 {
+			return fmt.Errorf("%d blobs are in the archive tier and no rehydration was requested for them. Run with -rehydrate first", counts.archived)
+		}
+		log.Printf("Waiting for %d blobs to be rehydrated, checking again in %d minutes", counts.pending(), pollInterval)
+		time.Sleep(time.Duration(pollInterval) * time.Minute)
+	}
+}
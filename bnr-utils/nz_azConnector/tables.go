@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"netezza-utils/bnr-utils/nzbackup"
+)
+
+// selectTables reads the schema.xml of every increment and keeps the blobs
+// needed to restore the given tables: the metadata plus their data files.
+func (cn *Conn) selectTables(tables string, uniqueid string, blobnames []string, relpaths []string) ([]string, []string, error) {
+	filter, err := nzbackup.ParseTableFilter(tables)
+	if err != nil {
+		return nil, nil, err
+	}
+	objids, matched, err := filter.Resolve(relpaths, func(relpath string) ([]byte, error) {
+		data, found, err := cn.readBlob(uniqueid + "/" + relpath)
+		if err == nil && !found {
+			err = fmt.Errorf("%s not found", relpath)
+		}
+		return data, err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to select tables to download: %v", err)
+	}
+	for _, table := range matched {
+		log.Printf("Downloading table %s (objid %s)", table, table.ObjID)
+	}
+
+	var selectedBlobs, selectedRelpaths []string
+	for i, relpath := range relpaths {
+		if nzbackup.KeepForTables(relpath, objids) {
+			selectedBlobs = append(selectedBlobs, blobnames[i])
+			selectedRelpaths = append(selectedRelpaths, relpath)
+		}
+	}
+	log.Printf("Downloading %d of %d files for %d tables", len(selectedBlobs), len(blobnames), len(matched))
+	return selectedBlobs, selectedRelpaths, nil
+}
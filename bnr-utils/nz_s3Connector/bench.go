@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Bench uploads and downloads synthetic files under a scratch prefix of the
+// unique id with every setting of -bench-matrix, using the same uploader and
+// downloader as backups, reports the throughput, request count and memory of
+// each and recommends the fastest. The scratch objects and files are removed
+// afterwards.
+func (s3Conn *S3Conn) Bench(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	matrix, err := nzbackup.ParseBenchMatrix(otherArgs.benchMatrix, nzbackup.BenchSetting{
+		Streams: int(s3Conn.streams), BlockSize: int(s3Conn.blockSize), ParallelJobs: int(otherArgs.parallelJobs)})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if otherArgs.benchSize <= 0 {
+		log.Fatalf("-bench-size must be a positive number of MB")
+	}
+	size := otherArgs.benchSize * 1024 * 1024
+
+	// with -dir, the local disk of the backups is part of the benchmark
+	localdir, err := os.MkdirTemp(bkp.dirs, "nzbench")
+	if err != nil {
+		log.Fatalf("Unable to create scratch directory: %v", err)
+	}
+	defer os.RemoveAll(localdir)
+	files, err := nzbackup.WriteBenchFiles(localdir, nzbackup.MaxParallelJobs(matrix), size)
+	if err != nil {
+		log.Fatalf("Unable to write benchmark data: %v", err)
+	}
+
+	// scratch objects are never locked, so that they can be deleted
+	bench := *s3Conn
+	bench.retainUntilDate = nil
+	bench.legalHold = false
+	scratch := nzbackup.BenchPath()
+	log.Printf("Benchmarking %d settings with %s files under %s in s3 bucket %s",
+		len(matrix), nzbackup.FormatSize(size), filepath.Join(otherArgs.uniqueId, scratch), s3Conn.bucketUrl)
+
+	var results []nzbackup.BenchResult
+	for _, setting := range matrix {
+		bench.streams = int64(setting.Streams)
+		bench.blockSize = int64(setting.BlockSize)
+		result := nzbackup.BenchResult{Setting: setting, Bytes: int64(setting.ParallelJobs) * size}
+		sampler := nzbackup.SampleMemory()
+
+		start, before := time.Now(), s3Conn.metrics.Requests()
+		var wg sync.WaitGroup
+		for i := 0; i < setting.ParallelJobs; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				bench.uploadFileToS3(files[i], cfg, otherArgs.uniqueId, filepath.Join(scratch, filepath.Base(files[i])))
+			}()
+		}
+		wg.Wait()
+		result.Upload, result.UploadRequests = time.Since(start), s3Conn.metrics.Requests()-before
+
+		start, before = time.Now(), s3Conn.metrics.Requests()
+		for i := 0; i < setting.ParallelJobs; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := filepath.Join(otherArgs.uniqueId, scratch, filepath.Base(files[i]))
+				bench.downloadFileFromS3(files[i]+".download", cfg, key)
+			}()
+		}
+		wg.Wait()
+		result.Download, result.DownloadRequests = time.Since(start), s3Conn.metrics.Requests()-before
+		result.PeakMemory = sampler.Stop()
+		log.Println(result)
+		results = append(results, result)
+	}
+
+	for _, f := range files {
+		_, err := s3Conn.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+			Bucket: aws.String(s3Conn.bucketUrl),
+			Key:    aws.String(filepath.Join(otherArgs.uniqueId, scratch, filepath.Base(f))),
+		})
+		if err != nil {
+			log.Printf("Unable to delete scratch object of %s: %v", f, err)
+		}
+	}
+	if best, ok := nzbackup.RecommendBench(results); ok {
+		up, down := best.Throughput()
+		log.Printf("Recommended: %s (upload %.1f MB/s, download %.1f MB/s)", best.Setting, up, down)
+	}
+}
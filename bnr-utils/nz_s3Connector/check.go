@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// explainS3Error adds the likely cause to the errors of the -check steps.
+func explainS3Error(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "InvalidAccessKeyId":
+		return fmt.Errorf("the access key is not known to the endpoint: %w", err)
+	case "SignatureDoesNotMatch":
+		return fmt.Errorf("the secret key does not match the access key: %w", err)
+	case "AccessDenied", "Forbidden":
+		return fmt.Errorf("permission denied: %w", err)
+	case "NoSuchBucket", "NotFound":
+		return fmt.Errorf("the bucket does not exist: %w", err)
+	case "PermanentRedirect", "MovedPermanently", "AuthorizationHeaderMalformed", "IllegalLocationConstraintException":
+		return fmt.Errorf("the bucket is in another region than -region or -endpoint: %w", err)
+	}
+	return err
+}
+
+// Check validates the credentials, the bucket and every permission the
+// connector needs by writing, reading and deleting probe objects under the
+// unique id, and reports which step failed.
+func (s3Conn *S3Conn) Check(cfg aws.Config, otherArgs OtherArgs) {
+	if s3Conn.bucketUrl == "" {
+		log.Fatalf("Missing required field: bucket-url is required with -check")
+	}
+	client := s3Conn.client
+	ctx := context.TODO()
+	probe := filepath.Join(otherArgs.uniqueId, nzbackup.ProbePath())
+	multipartProbe := probe + "-multipart"
+	data := nzbackup.ProbeData(probe)
+	log.Printf("Checking access to s3 bucket %s with probe object %s", s3Conn.bucketUrl, probe)
+
+	var p nzbackup.Preflight
+	run := func(name string, requires []string, fn func() error) {
+		log.Println(p.Run(name, requires, func() error {
+			return explainS3Error(fn())
+		}))
+	}
+
+	run("credentials", nil, func() error {
+		_, err := cfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return fmt.Errorf("set -access-key and -secret-key: %w", err)
+		}
+		return nil
+	})
+	if s3Conn.endPoint == "" {
+		// with a custom endpoint the region is not checked against AWS
+		run("region", []string{"credentials"}, func() error {
+			region, err := manager.GetBucketRegion(ctx, client, s3Conn.bucketUrl)
+			if err != nil {
+				return err
+			}
+			if region != cfg.Region {
+				return fmt.Errorf("the bucket is in region %s, -region is %q", region, cfg.Region)
+			}
+			return nil
+		})
+	}
+	run("bucket", []string{"credentials"}, func() error {
+		_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s3Conn.bucketUrl)})
+		return err
+	})
+	run("list (s3:ListBucket)", []string{"bucket"}, func() error {
+		_, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(s3Conn.bucketUrl),
+			Prefix:  aws.String(otherArgs.uniqueId + "/"),
+			MaxKeys: aws.Int32(1),
+		})
+		return err
+	})
+	run("put (s3:PutObject)", []string{"bucket"}, func() error {
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s3Conn.bucketUrl),
+			Key:    aws.String(probe),
+			Body:   bytes.NewReader(data),
+		})
+		return err
+	})
+	run("get (s3:GetObject)", []string{"put (s3:PutObject)"}, func() error {
+		out, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s3Conn.bucketUrl),
+			Key:    aws.String(probe),
+		})
+		if err != nil {
+			return err
+		}
+		defer out.Body.Close()
+		got, err := io.ReadAll(out.Body)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			return fmt.Errorf("read back %d bytes that differ from the %d bytes written", len(got), len(data))
+		}
+		return nil
+	})
+	run("multipart upload (s3:PutObject)", []string{"bucket"}, func() error {
+		return s3Conn.checkMultipart(client, multipartProbe, data)
+	})
+	run("delete (s3:DeleteObject)", []string{"put (s3:PutObject)"}, func() error {
+		for _, key := range []string{probe, multipartProbe} {
+			_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s3Conn.bucketUrl),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err := p.Err(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("Pre-flight check of s3 bucket %s passed", s3Conn.bucketUrl)
+}
+
+// checkMultipart uploads the probe as a single part multipart upload, the way
+// large backup files are uploaded, aborting the upload if a step fails.
+func (s3Conn *S3Conn) checkMultipart(client *s3.Client, key string, data []byte) error {
+	ctx := context.TODO()
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	part, err := client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s3Conn.bucketUrl),
+		Key:        aws.String(key),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       bytes.NewReader(data),
+	})
+	if err == nil {
+		_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String(s3Conn.bucketUrl),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: []types.CompletedPart{{ETag: part.ETag, PartNumber: aws.Int32(1)}},
+			},
+		})
+	}
+	if err != nil {
+		_, abortErr := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s3Conn.bucketUrl),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		if abortErr != nil {
+			return fmt.Errorf("%v, and aborting the upload failed: %v", err, abortErr)
+		}
+	}
+	return err
+}
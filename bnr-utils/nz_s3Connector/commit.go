@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// manifestParameters returns the connector parameters recorded in manifests.
+func (s3Conn *S3Conn) manifestParameters() map[string]string {
+	return map[string]string{
+		"connector":   "nz_s3Connector",
+		"blocksize":   strconv.FormatInt(s3Conn.blockSize, 10),
+		"streams":     strconv.FormatInt(s3Conn.streams, 10),
+		"compression": "none",
+		"encryption":  "none",
+	}
+}
+
+// uploadCommits writes the manifest and then the commit object of every
+// uploaded backupset. It must only be called once all files of the run were
+// uploaded successfully.
+func (s3Conn *S3Conn) uploadCommits(cfg aws.Config, uniqueId string, uploaded *nzbackup.UploadSet) {
+	// control objects are small enough for a single request
+	uploader, err := s3Conn.getUploader(cfg, -1)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	for bsdir, manifest := range uploaded.Manifests(s3Conn.manifestParameters()) {
+		data, err := manifest.Marshal()
+		if err != nil {
+			log.Fatalf("Failed to create manifest for %s. Err: %v", bsdir, err)
+		}
+		relFilePath := nzbackup.ManifestPath(bsdir)
+		_, err = uploader.Upload(context.TODO(), s3Conn.putObjectInput(bytes.NewReader(data), uniqueId, relFilePath))
+		if err != nil {
+			log.Fatalf("Failed to upload manifest %s. Err: %v", relFilePath, err)
+		}
+
+		data, err = nzbackup.NewCommit(manifest).Marshal()
+		if err != nil {
+			log.Fatalf("Failed to create commit object for %s. Err: %v", bsdir, err)
+		}
+		relFilePath = nzbackup.CommitPath(bsdir)
+		_, err = uploader.Upload(context.TODO(), s3Conn.putObjectInput(bytes.NewReader(data), uniqueId, relFilePath))
+		if err != nil {
+			log.Fatalf("Failed to upload commit object %s. Err: %v", relFilePath, err)
+		}
+		log.Printf("Manifest and commit object uploaded for %d files of %s", len(manifest.Files), bsdir)
+	}
+}
+
+// readObject returns the content of a small object. found is false when the object does not exist.
+func (s3Conn *S3Conn) readObject(client *s3.Client, key string) (data []byte, found bool, err error) {
+	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer out.Body.Close()
+	data, err = io.ReadAll(out.Body)
+	return data, true, err
+}
+
+func (s3Conn *S3Conn) readCommit(client *s3.Client, key string) (nzbackup.Commit, error) {
+	data, found, err := s3Conn.readObject(client, key)
+	if err == nil && !found {
+		err = errors.New("Commit object " + key + " not found")
+	}
+	if err != nil {
+		return nzbackup.Commit{}, err
+	}
+	return nzbackup.ParseCommit(data)
+}
+
+// selectBackupKeys returns the keys of the backup files to download. When
+// bkpath is a single backupset with a manifest, the keys come from the
+// manifest, which is returned as well. Otherwise the backup is listed and
+// checked for completeness. The modification time of every key, as recorded
+// in the manifest, is returned to locate increments in time. It is nil
+// without a manifest, as the upload time of a key says nothing of when its
+// increment was backed up.
+func (s3Conn *S3Conn) selectBackupKeys(client *s3.Client, otherArgs OtherArgs, bkpath string) ([]string, map[string]time.Time, *nzbackup.Manifest) {
+	relbkpath, err := filepath.Rel(otherArgs.uniqueId, bkpath)
+	if err != nil {
+		log.Fatalf("Error in fetching download relative path: %v", err)
+	}
+
+	if nzbackup.IsBackupsetDir(relbkpath) {
+		data, found, err := s3Conn.readObject(client, filepath.Join(bkpath, nzbackup.ManifestName))
+		if err != nil {
+			log.Fatalf("Failed to read manifest of %s. Err: %v", bkpath, err)
+		}
+		if found {
+			manifest, err := nzbackup.ParseManifest(data)
+			if err != nil {
+				log.Fatalf("Failed to read manifest of %s. Err: %v", bkpath, err)
+			}
+			_, committed, err := s3Conn.readObject(client, filepath.Join(bkpath, nzbackup.CommitName))
+			if err != nil {
+				log.Fatalf("Failed to read commit object of %s. Err: %v", bkpath, err)
+			}
+			if !committed {
+				s3Conn.reportIncomplete(otherArgs, []string{relbkpath + " has a manifest but no commit object, its upload may not have completed"})
+			}
+			log.Printf("Using manifest of %s listing %d files", bkpath, len(manifest.Files))
+			keys := make([]string, 0, len(manifest.Files))
+			modtimes := make(map[string]time.Time, len(manifest.Files))
+			for _, f := range manifest.Files {
+				key := filepath.Join(bkpath, filepath.FromSlash(f.Path))
+				keys = append(keys, key)
+				modtimes[key] = f.ModTime
+			}
+			return keys, modtimes, &manifest
+		}
+	}
+
+	var keys []string
+	s3Conn.listBackupObjects(client, bkpath, func(obj types.Object) {
+		keys = append(keys, *obj.Key)
+	})
+	s3Conn.checkComplete(client, otherArgs, keys)
+
+	files := keys[:0]
+	for _, key := range keys {
+		if !nzbackup.IsControlPath(key) {
+			files = append(files, key)
+		}
+	}
+	return files, nil, nil
+}
+
+// checkComplete refuses to continue when a listed backupset has no commit
+// object or misses files, unless -allow-incomplete is set.
+func (s3Conn *S3Conn) checkComplete(client *s3.Client, otherArgs OtherArgs, keys []string) {
+	relpaths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		relpath, err := filepath.Rel(otherArgs.uniqueId, key)
+		if err != nil {
+			log.Fatalf("Error in fetching download relative path: %v", err)
+		}
+		relpaths = append(relpaths, relpath)
+	}
+
+	problems, legacy, err := nzbackup.CheckComplete(relpaths, func(commitpath string) (nzbackup.Commit, error) {
+		return s3Conn.readCommit(client, filepath.Join(otherArgs.uniqueId, commitpath))
+	})
+	if err != nil {
+		log.Fatalf("Failed to read commit object. Err: %v", err)
+	}
+	for _, warning := range legacy {
+		log.Printf("WARNING: %s", warning)
+	}
+	s3Conn.reportIncomplete(otherArgs, problems)
+}
+
+func (s3Conn *S3Conn) reportIncomplete(otherArgs OtherArgs, problems []string) {
+	if len(problems) == 0 {
+		return
+	}
+	for _, problem := range problems {
+		log.Printf("WARNING: %s", problem)
+	}
+	if !*otherArgs.allowIncomplete {
+		log.Fatalf("Backup in s3 bucket %s is incomplete. Use -allow-incomplete to download it anyway.", s3Conn.bucketUrl)
+	}
+	log.Printf("WARNING: downloading incomplete backup because -allow-incomplete is set")
+}
+
+// verifyDownload checks the downloaded files against the manifest and
+// restores their modification times and permissions. locate maps manifest
+// paths to local files.
+func (s3Conn *S3Conn) verifyDownload(manifest *nzbackup.Manifest, locate func(path string) (string, bool), otherArgs OtherArgs) {
+	problems := manifest.Verify(locate, *otherArgs.checksum)
+	for _, problem := range problems {
+		log.Printf("ERROR: %s", problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("%d downloaded files do not match the manifest", len(problems))
+	}
+	err := manifest.ApplyAttributes(locate)
+	if err != nil {
+		log.Fatalf("Failed to restore file attributes from manifest. Err: %v", err)
+	}
+	log.Printf("All %d files match the manifest", len(manifest.Files))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fifoFile is a data file streamed into a named pipe instead of written to disk.
+type fifoFile struct {
+	key       string
+	relpath   string
+	localpath string
+}
+
+// serveFifos creates a named pipe for every data file and streams the object
+// into it once nzrestore opens it. It returns when every pipe has been read
+// to the end, after comparing what was streamed with the manifest.
+func (s3Conn *S3Conn) serveFifos(client *s3.Client, fifos []fifoFile, manifest *nzbackup.Manifest, otherArgs OtherArgs) {
+	for _, f := range fifos {
+		err := nzbackup.MakeFifo(f.localpath)
+		if err != nil {
+			log.Fatalf("Unable to create named pipe %s. Err: %v", f.localpath, err)
+		}
+	}
+	log.Printf("Created %d named pipes, waiting for nzrestore to read them", len(fifos))
+
+	// streamed files by manifest path
+	streamed := make(map[string]nzbackup.ManifestFile)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, f := range fifos {
+		wg.Add(1)
+		go func() {
+			file := s3Conn.streamToFifo(client, f, *otherArgs.checksum)
+			log.Printf("File %s streamed successfully", f.key)
+			if bsdir, ok := nzbackup.BackupsetDir(f.relpath); ok {
+				mu.Lock()
+				streamed[strings.TrimPrefix(filepath.ToSlash(f.relpath), bsdir+"/")] = file
+				mu.Unlock()
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	log.Printf("Total files streamed: %d", len(fifos))
+
+	if manifest != nil {
+		problems := manifest.VerifyStreams(streamed, *otherArgs.checksum)
+		for _, problem := range problems {
+			log.Printf("ERROR: %s", problem)
+		}
+		if len(problems) > 0 {
+			log.Fatalf("%d streamed files do not match the manifest", len(problems))
+		}
+	}
+}
+
+// streamToFifo waits for nzrestore to open the named pipe of a data file and
+// writes the object into it, reading blocksize MB ranges up to streams ranges
+// ahead of nzrestore.
+func (s3Conn *S3Conn) streamToFifo(client *s3.Client, f fifoFile, checksum bool) nzbackup.ManifestFile {
+	// opening blocks until nzrestore opens the pipe for reading
+	fifo, err := os.OpenFile(f.localpath, os.O_WRONLY, 0)
+	if err != nil {
+		log.Fatalf("Unable to open named pipe %s. Err: %v", f.localpath, err)
+	}
+	defer fifo.Close()
+
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Key:    aws.String(f.key),
+	})
+	if err != nil {
+		log.Fatalf("Failed to read size of object %s. Err: %v", f.key, err)
+	}
+	ranges := nzbackup.NewRangeReader(aws.ToInt64(head.ContentLength), s3Conn.blockSize*1024*1024, int(s3Conn.streams),
+		func(offset, count int64) ([]byte, error) {
+			out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+				Bucket: aws.String(s3Conn.bucketUrl),
+				Key:    aws.String(f.key),
+				Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+count-1)),
+			})
+			if err != nil {
+				return nil, err
+			}
+			defer out.Body.Close()
+			return io.ReadAll(out.Body)
+		})
+	defer ranges.Close()
+
+	stream := nzbackup.NewStreamReader(ranges, 0644, checksum)
+	_, err = io.Copy(fifo, stream)
+	if err != nil {
+		log.Fatalf("Failed to stream object %s into %s. Err: %v", f.key, f.localpath, err)
+	}
+	return stream.File()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// listObjectInfos lists the backup files stored under bkpath with their sizes.
+func (s3Conn *S3Conn) listObjectInfos(client *s3.Client, uniqueId string, bkpath string) []nzbackup.ObjectInfo {
+	var objects []nzbackup.ObjectInfo
+	s3Conn.listBackupObjects(client, bkpath, func(obj types.Object) {
+		if nzbackup.IsControlPath(*obj.Key) {
+			return
+		}
+		relpath, err := filepath.Rel(uniqueId, *obj.Key)
+		if err != nil {
+			log.Fatalf("Error in fetching relative path: %v", err)
+		}
+		objects = append(objects, nzbackup.ObjectInfo{Path: relpath, Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)})
+	})
+	return objects
+}
+
+// inspectBackupset summarizes the backupset stored under bkpath by reading
+// only its md files and its manifest, if any.
+func (s3Conn *S3Conn) inspectBackupset(client *s3.Client, uniqueId string, bkpath string) *nzbackup.Inspection {
+	objects := s3Conn.listObjectInfos(client, uniqueId, bkpath)
+	if len(objects) == 0 {
+		log.Fatalf("No objects found under %s in s3 bucket %s", bkpath, s3Conn.bucketUrl)
+	}
+
+	var manifest *nzbackup.Manifest
+	data, found, err := s3Conn.readObject(client, filepath.Join(bkpath, nzbackup.ManifestName))
+	if err == nil && found {
+		var m nzbackup.Manifest
+		m, err = nzbackup.ParseManifest(data)
+		manifest = &m
+	}
+	if err != nil {
+		log.Fatalf("Failed to read manifest of %s. Err: %v", bkpath, err)
+	}
+
+	inspection, err := nzbackup.Inspect(objects, manifest, func(relpath string) ([]byte, error) {
+		data, found, err := s3Conn.readObject(client, filepath.Join(uniqueId, relpath))
+		if err == nil && !found {
+			err = fmt.Errorf("%s not found", relpath)
+		}
+		return data, err
+	})
+	if err != nil {
+		log.Fatalf("Failed to inspect backup %s. Err: %v", bkpath, err)
+	}
+	return inspection
+}
+
+// Inspect reports the increments, tables and data file sizes of a backupset
+// by reading only its md files.
+func (s3Conn *S3Conn) Inspect(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	inspection := s3Conn.inspectBackupset(s3Conn.client, otherArgs.uniqueId, bkpath)
+	log.Printf("Backupset %s:", bkpath)
+	for _, line := range inspection.Report() {
+		log.Println(line)
+	}
+}
+
+// Diff reports the tables added, dropped or changed in size between two backupsets.
+func (s3Conn *S3Conn) Diff(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	before, after, err := nzbackup.ParseBackupsetPair(otherArgs.diff)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	client := s3Conn.client
+	dbpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname)
+	// the two backupsets are independent prefixes, inspected concurrently
+	inspections, _ := nzbackup.ListConcurrently([]string{before, after}, 2, func(id string) ([]*nzbackup.Inspection, error) {
+		return []*nzbackup.Inspection{s3Conn.inspectBackupset(client, otherArgs.uniqueId, filepath.Join(dbpath, id))}, nil
+	})
+	changes, err := nzbackup.DiffTables(inspections[0], inspections[1])
+	if err != nil {
+		log.Fatalf("Unable to diff backupsets %s and %s. Err: %v", before, after, err)
+	}
+
+	log.Printf("Differences from backupset %s to %s: %d", before, after, len(changes))
+	for _, change := range changes {
+		log.Println(change)
+	}
+}
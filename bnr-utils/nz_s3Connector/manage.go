@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxCopyObjectSize is the largest object CopyObject copies in one request.
+const maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// listAllObjects lists every object stored under bkpath, including the commit
+// and manifest objects.
+func (s3Conn *S3Conn) listAllObjects(client *s3.Client, uniqueId string, bkpath string) []nzbackup.ObjectInfo {
+	var objects []nzbackup.ObjectInfo
+	s3Conn.listBackupObjects(client, bkpath, func(obj types.Object) {
+		relpath, err := filepath.Rel(uniqueId, *obj.Key)
+		if err != nil {
+			log.Fatalf("Error in fetching relative path: %v", err)
+		}
+		objects = append(objects, nzbackup.ObjectInfo{Path: relpath, Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)})
+	})
+	return objects
+}
+
+// List reports the backupsets stored under the unique id, narrowed down by
+// -npshost, -db and -backupset when given. With -hierarchical, the backupset
+// directories are found with delimiter listings and listed concurrently
+// instead of listing every object under bkpath with one paginated listing.
+func (s3Conn *S3Conn) List(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	var objects []nzbackup.ObjectInfo
+	if *otherArgs.hierarchical {
+		relpath, _ := filepath.Rel(otherArgs.uniqueId, bkpath)
+		parallel := int(otherArgs.parallelJobs)
+		bsdirs, _ := nzbackup.ListDirs(bkpath, nzbackup.BackupsetLevels(relpath), parallel, func(dir string) ([]string, error) {
+			return s3Conn.listSubdirs(s3Conn.client, dir), nil
+		})
+		log.Printf("Listing %d backupsets under %s", len(bsdirs), bkpath)
+		objects, _ = nzbackup.ListConcurrently(bsdirs, parallel, func(bsdir string) ([]nzbackup.ObjectInfo, error) {
+			return s3Conn.listAllObjects(s3Conn.client, otherArgs.uniqueId, bsdir), nil
+		})
+	} else {
+		objects = s3Conn.listAllObjects(s3Conn.client, otherArgs.uniqueId, bkpath)
+	}
+	backupsets := nzbackup.ListBackupsets(objects)
+	for _, bs := range backupsets {
+		log.Println(bs)
+	}
+	log.Printf("Total backupsets found under %s: %d", bkpath, len(backupsets))
+}
+
+// Verify checks the backupset in the bucket against its commit object and
+// manifest without downloading it.
+func (s3Conn *S3Conn) Verify(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	client := s3Conn.client
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	bsdir, _ := filepath.Rel(otherArgs.uniqueId, bkpath)
+
+	var files []nzbackup.ObjectInfo
+	for _, obj := range s3Conn.listAllObjects(client, otherArgs.uniqueId, bkpath) {
+		if !nzbackup.IsControlPath(obj.Path) {
+			files = append(files, obj)
+		}
+	}
+	if len(files) == 0 {
+		log.Fatalf("No objects found under %s in s3 bucket %s", bkpath, s3Conn.bucketUrl)
+	}
+
+	var commit *nzbackup.Commit
+	data, found, err := s3Conn.readObject(client, filepath.Join(bkpath, nzbackup.CommitName))
+	if err == nil && found {
+		var c nzbackup.Commit
+		c, err = nzbackup.ParseCommit(data)
+		commit = &c
+	}
+	if err != nil {
+		log.Fatalf("Failed to read commit object of %s. Err: %v", bkpath, err)
+	}
+	var manifest *nzbackup.Manifest
+	data, found, err = s3Conn.readObject(client, filepath.Join(bkpath, nzbackup.ManifestName))
+	if err == nil && found {
+		var m nzbackup.Manifest
+		m, err = nzbackup.ParseManifest(data)
+		manifest = &m
+	}
+	if err != nil {
+		log.Fatalf("Failed to read manifest of %s. Err: %v", bkpath, err)
+	}
+
+	problems := nzbackup.VerifyBackupset(bsdir, files, commit, manifest)
+	for _, problem := range problems {
+		log.Printf("ERROR: %s", problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("Backupset %s failed verification, %d problems found", bsdir, len(problems))
+	}
+	log.Printf("Backupset %s verified: all %d files are present with the expected size", bsdir, len(files))
+}
+
+// Delete removes every object of the backupset from the bucket. The commit
+// object goes first so that an interrupted delete leaves an incomplete
+// backupset rather than one that looks restorable.
+func (s3Conn *S3Conn) Delete(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	client := s3Conn.client
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	var relpaths []string
+	for _, obj := range s3Conn.listAllObjects(client, otherArgs.uniqueId, bkpath) {
+		relpaths = append(relpaths, obj.Path)
+	}
+	if len(relpaths) == 0 {
+		log.Fatalf("No objects found under %s in s3 bucket %s", bkpath, s3Conn.bucketUrl)
+	}
+	relpaths = nzbackup.ControlLast(relpaths)
+	slices.Reverse(relpaths)
+
+	log.Printf("Deleting %d objects under %s from s3 bucket %s", len(relpaths), bkpath, s3Conn.bucketUrl)
+	var batch []types.ObjectIdentifier
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		out, err := client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(s3Conn.bucketUrl),
+			Delete: &types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			log.Fatalf("Failed to delete objects. Err: %v", err)
+		}
+		for _, e := range out.Errors {
+			log.Printf("ERROR: %s: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+		if len(out.Errors) > 0 {
+			log.Fatalf("Failed to delete %d objects, they may be protected by object lock", len(out.Errors))
+		}
+		batch = batch[:0]
+	}
+	for _, relpath := range relpaths {
+		key := filepath.Join(otherArgs.uniqueId, relpath)
+		batch = append(batch, types.ObjectIdentifier{Key: aws.String(key)})
+		// control objects are deleted on their own, before any backup file
+		if nzbackup.IsControlPath(relpath) || len(batch) == 1000 {
+			flush()
+		}
+	}
+	flush()
+	log.Printf("Total objects deleted: %d", len(relpaths))
+}
+
+// Copy copies every object of the backup under the unique id given by
+// -copy-to within the bucket, without downloading it. The commit object of
+// a backupset is copied last.
+func (s3Conn *S3Conn) Copy(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	client := s3Conn.client
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	objects := s3Conn.listAllObjects(client, otherArgs.uniqueId, bkpath)
+	if len(objects) == 0 {
+		log.Fatalf("No objects found under %s in s3 bucket %s", bkpath, s3Conn.bucketUrl)
+	}
+	sizes := make(map[string]int64, len(objects))
+	var files, control []string
+	for _, obj := range objects {
+		sizes[obj.Path] = obj.Size
+		if nzbackup.IsControlPath(obj.Path) {
+			control = append(control, obj.Path)
+		} else {
+			files = append(files, obj.Path)
+		}
+	}
+	log.Printf("Copying %d objects under %s to unique-id %s", len(objects), bkpath, otherArgs.copyTo)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, otherArgs.parallelJobs)
+	for _, relpath := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			s3Conn.copyObject(client, filepath.Join(otherArgs.uniqueId, relpath), filepath.Join(otherArgs.copyTo, relpath), sizes[relpath])
+			wg.Done()
+			<-sem
+		}()
+	}
+	wg.Wait()
+	for _, relpath := range nzbackup.ControlLast(control) {
+		s3Conn.copyObject(client, filepath.Join(otherArgs.uniqueId, relpath), filepath.Join(otherArgs.copyTo, relpath), sizes[relpath])
+	}
+	log.Printf("Total objects copied: %d", len(objects))
+}
+
+// copyObject copies an object within the bucket, as a multipart copy of
+// blocksize MB parts, or larger ones for objects over 10,000 blocks, when it is
+// too large for a single request.
+func (s3Conn *S3Conn) copyObject(client *s3.Client, srcKey string, dstKey string, size int64) {
+	source := (&url.URL{Path: s3Conn.bucketUrl + "/" + srcKey}).EscapedPath()
+	if size <= maxCopyObjectSize {
+		_, err := client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+			Bucket:     aws.String(s3Conn.bucketUrl),
+			Key:        aws.String(dstKey),
+			CopySource: aws.String(source),
+		})
+		if err != nil {
+			log.Fatalf("Failed to copy object %s to %s. Err: %v", srcKey, dstKey, err)
+		}
+		return
+	}
+
+	partSize, err := s3Conn.partSize(size)
+	if err != nil {
+		log.Fatalf("Cannot copy object %s. Err: %v", srcKey, err)
+	}
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		log.Fatalf("Failed to read object %s. Err: %v", srcKey, err)
+	}
+	upload, err := client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(s3Conn.bucketUrl),
+		Key:      aws.String(dstKey),
+		Metadata: head.Metadata,
+	})
+	if err != nil {
+		log.Fatalf("Failed to copy object %s to %s. Err: %v", srcKey, dstKey, err)
+	}
+	abort := func(err error) {
+		client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s3Conn.bucketUrl),
+			Key:      aws.String(dstKey),
+			UploadId: upload.UploadId,
+		})
+		log.Fatalf("Failed to copy object %s to %s. Err: %v", srcKey, dstKey, err)
+	}
+
+	var parts []types.CompletedPart
+	for offset := int64(0); offset < size; offset += partSize {
+		number := int32(len(parts) + 1)
+		out, err := client.UploadPartCopy(context.TODO(), &s3.UploadPartCopyInput{
+			Bucket:          aws.String(s3Conn.bucketUrl),
+			Key:             aws.String(dstKey),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, min(offset+partSize, size)-1)),
+			PartNumber:      aws.Int32(number),
+			UploadId:        upload.UploadId,
+		})
+		if err != nil {
+			abort(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(number)})
+	}
+	_, err = client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s3Conn.bucketUrl),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort(err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,11 +15,14 @@ import (
 	"sync"
 	"time"
 
+	"netezza-utils/bnr-utils/nzbackup"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Conn struct {
@@ -28,28 +33,79 @@ type S3Conn struct {
 	endPoint        string
 	streams         int64
 	blockSize       int64
+	minBlockSize    int64
+	maxBlockSize    int64
+	objectLockMode  string
+	retainDays      int64
+	retainUntil     string
+	retainUntilDate *time.Time
+	legalHold       bool
+	objectTags      bool
+	http2           bool
+	// client is shared by every request of the run, so that connections are reused
+	client  *s3.Client
+	metrics *nzbackup.ConnMetrics
 }
 
 type BackupInfo struct {
-	dbname      string
-	dirs        string
-	npshost     string
-	backupsetID string
+	dbname        string
+	dirs          string
+	npshost       string
+	backupsetID   string
+	restoreAsHost string
+	restoreAsDb   string
+	increment     int
+	asOf          string
+	tables        string
+	before        string
 }
 
 type OtherArgs struct {
-	download     *bool
-	upload       *bool
-	parallelJobs int64
-	logFileDir   string
-	uniqueId     string
+	download         *bool
+	upload           *bool
+	parallelJobs     int64
+	logFileDir       string
+	uniqueId         string
+	rehydrate        *bool
+	rehydrateStatus  *bool
+	waitRehydrate    *bool
+	rehydrateDays    int64
+	rehydrateTier    string
+	pollInterval     int64
+	requireImmutable *bool
+	allowIncomplete  *bool
+	checksum         *bool
+	inspect          *bool
+	diff             string
+	listLocal        *bool
+	skipValidation   *bool
+	stdinPath        string
+	fifo             *bool
+	list             *bool
+	hierarchical     *bool
+	verify           *bool
+	delete           *bool
+	copyTo           string
+	configPath       string
+	profile          string
+	showConfig       *bool
+	check            *bool
+	bench            *bool
+	benchMatrix      string
+	benchSize        int64
 }
 
 func parseArgs(s3Conn *S3Conn, backupinfo *BackupInfo, otherArgs *OtherArgs) {
 	flag.StringVar(&backupinfo.dbname, "db", "", "Database name")
 	flag.StringVar(&backupinfo.dirs, "dir", "", "Full path to the directory in which the backup already exists or should be downloaded. Enclose in double quotes if there are multiple directories.")
 	flag.StringVar(&backupinfo.npshost, "npshost", "", "Name of the NPS host as it appears in the backups")
-	flag.StringVar(&backupinfo.backupsetID, "backupset", "", "Name of the backupset to be uploaded/downloaded.")
+	flag.StringVar(&backupinfo.backupsetID, "backupset", "", "Name of the backupset to be uploaded/downloaded. Use latest for the newest backupset in the cloud.")
+	flag.StringVar(&backupinfo.before, "before", "", "Use the newest backupset started at or before this time and its increments completed by then (YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss or RFC3339)")
+	flag.StringVar(&backupinfo.restoreAsHost, "restore-as-host", "", "NPS host name the downloaded backup is restored under, if different from -npshost")
+	flag.StringVar(&backupinfo.restoreAsDb, "restore-as-db", "", "Database name the downloaded backup is restored under, if different from -db")
+	flag.IntVar(&backupinfo.increment, "increment", 0, "Download only the increments needed to restore the backupset up to this increment")
+	flag.StringVar(&backupinfo.asOf, "as-of", "", "Download only the increments needed to restore the backupset as of this time (YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss or RFC3339)")
+	flag.StringVar(&backupinfo.tables, "tables", "", "Download only the data of these tables, a comma separated list of db.schema.table that may contain globs")
 	flag.StringVar(&otherArgs.logFileDir, "logfiledir", "", "Logfile directory for this utility")
 
 	flag.StringVar(&s3Conn.accessKeyId, "access-key", "", "Access Key Id to access AWS s3/IBM cloud")
@@ -58,12 +114,57 @@ func parseArgs(s3Conn *S3Conn, backupinfo *BackupInfo, otherArgs *OtherArgs) {
 	flag.StringVar(&s3Conn.secretAccessKey, "secret-key", "", "Secret Access Key to access access AWS s3/IBM cloud")
 	flag.StringVar(&s3Conn.endPoint, "endpoint", "", "URL of the entry point for an AWS s3/IBM cloud. Mandatory for IBM cloud service.")
 	flag.Int64Var(&s3Conn.streams, "streams", 16, "Number of blocks to upload/download in parallel default 16")
-	flag.Int64Var(&s3Conn.blockSize, "blocksize", 100, "Block size in MB to upload/download file")
+	flag.Int64Var(&s3Conn.blockSize, "blocksize", 100, "Block size in MB to upload/download file. Uploads of files too large for 10,000 blocks of this size use larger blocks")
+	flag.Int64Var(&s3Conn.minBlockSize, "min-blocksize", 0, "Smallest block size in MB uploads may use. Default is the s3 minimum of 5 MB")
+	flag.Int64Var(&s3Conn.maxBlockSize, "max-blocksize", 0, "Largest block size in MB uploads may use. Default is the s3 maximum of 5 GB")
+	flag.StringVar(&s3Conn.objectLockMode, "object-lock-mode", "", "Object lock retention mode for uploaded objects: GOVERNANCE or COMPLIANCE")
+	flag.Int64Var(&s3Conn.retainDays, "retain-days", 0, "Number of days uploaded objects are locked against deletion and overwrite")
+	flag.StringVar(&s3Conn.retainUntil, "retain-until", "", "Date (YYYY-MM-DD or RFC3339) until which uploaded objects are locked")
+	flag.BoolVar(&s3Conn.legalHold, "legal-hold", false, "Place a legal hold on uploaded objects")
+	flag.BoolVar(&s3Conn.objectTags, "tags", false, "Also add the backup description stored in the object metadata as object tags")
+	flag.BoolVar(&s3Conn.http2, "http2", false, "Use HTTP/2 when the endpoint supports it instead of a connection per stream")
 
 	otherArgs.download = flag.Bool("download", false, "Download from cloud")
 	otherArgs.upload = flag.Bool("upload", false, "Upload from cloud")
+	otherArgs.listLocal = flag.Bool("list-local", false, "List the hosts, databases and backupsets found in the local backup directories")
+	otherArgs.skipValidation = flag.Bool("skip-validation", false, "Upload without checking that the local backup is complete nzbackup output")
+	flag.StringVar(&otherArgs.stdinPath, "stdin", "", "With -upload, upload the standard input as this file path relative to the backupset directory")
+	otherArgs.fifo = flag.Bool("fifo", false, "With -download, stream the table data files into named pipes for nzrestore instead of writing them to disk")
 	flag.Int64Var(&otherArgs.parallelJobs, "paralleljobs", 6, "Parallel jobs for upload/download")
 	flag.StringVar(&otherArgs.uniqueId, "unique-id", "", "Unique ID associated with the file transfer")
+
+	otherArgs.inspect = flag.Bool("inspect", false, "Report the increments, tables and data file sizes of the backupset by reading only its md files")
+	flag.StringVar(&otherArgs.diff, "diff", "", "Report tables added, dropped or changed in size between two backupsets, given as A,B")
+	otherArgs.list = flag.Bool("list", false, "List the backupsets stored under the unique-id with their file count, size and commit state")
+	otherArgs.hierarchical = flag.Bool("hierarchical", false, "With -list, find the backupsets level by level with delimiter listings and list them -paralleljobs at a time")
+	otherArgs.verify = flag.Bool("verify", false, "Check the backupset in the bucket against its commit object and manifest without downloading it")
+	otherArgs.delete = flag.Bool("delete", false, "Delete every object of the backupset from the bucket")
+	flag.StringVar(&otherArgs.copyTo, "copy-to", "", "Copy the backupset to this unique-id within the bucket without downloading it")
+	otherArgs.rehydrate = flag.Bool("rehydrate", false, "Request restore of archived (Glacier/Deep Archive) objects of the backupset")
+	otherArgs.rehydrateStatus = flag.Bool("rehydrate-status", false, "Report how many objects of the backupset are still archived or being restored")
+	otherArgs.waitRehydrate = flag.Bool("wait-rehydrate", false, "Wait until all objects of the backupset are readable before downloading")
+	flag.Int64Var(&otherArgs.rehydrateDays, "rehydrate-days", 7, "Number of days the restored copy of an archived object stays available")
+	flag.StringVar(&otherArgs.rehydrateTier, "rehydrate-tier", "Standard", "Retrieval tier for archived objects: Expedited, Standard or Bulk")
+	flag.Int64Var(&otherArgs.pollInterval, "poll-interval", 15, "Minutes to wait between checks while waiting for archived objects to be restored")
+	otherArgs.checksum = flag.Bool("checksum", false, "Record SHA-256 checksums in the backupset manifest on upload and verify them on download. Reads every file a second time")
+	otherArgs.allowIncomplete = flag.Bool("allow-incomplete", false, "Download backupsets without a commit object or with missing files, only logging a warning")
+	otherArgs.requireImmutable = flag.Bool("require-immutable", false, "Refuse to upload unless object lock is enabled on the bucket")
+	flag.StringVar(&otherArgs.configPath, "config", "", "Configuration file with named profiles. Default is ~/"+nzbackup.ConfigFileName)
+	flag.StringVar(&otherArgs.profile, "profile", "", "Profile of the configuration file supplying the flags not given on the command line. Default is the default profile, if any")
+	otherArgs.check = flag.Bool("check", false, "Check the credentials, bucket, region and the list, put, get, multipart upload and delete permissions with a probe object, and report the step that failed")
+	otherArgs.bench = flag.Bool("bench", false, "Upload and download synthetic data under a scratch prefix with every setting of -bench-matrix, report the throughput of each and recommend the fastest")
+	flag.StringVar(&otherArgs.benchMatrix, "bench-matrix", nzbackup.DefaultBenchMatrix, "Settings tried by -bench, as streams=N,N blocksize=N,N paralleljobs=N,N")
+	flag.Int64Var(&otherArgs.benchSize, "bench-size", 64, "Size in MB of each synthetic file transferred by -bench")
+	otherArgs.showConfig = flag.Bool("show-config", false, "Print the effective value and source of every flag, with secrets masked, and exit")
+}
+
+// standardEnv are the AWS SDK environment variables honored for the flags not
+// given on the command line or in an NZ_CONNECTOR_ variable.
+var standardEnv = map[string][]string{
+	"access-key": {"AWS_ACCESS_KEY_ID"},
+	"secret-key": {"AWS_SECRET_ACCESS_KEY"},
+	"region":     {"AWS_REGION", "AWS_DEFAULT_REGION"},
+	"endpoint":   {"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"},
 }
 
 func main() {
@@ -74,6 +175,19 @@ func main() {
 	// parse input args
 	parseArgs(&conn, &backupinfo, &otherArgs)
 	flag.Parse()
+	settings := nzbackup.NewSettings(flag.CommandLine, nzbackup.BackendS3)
+	if err := settings.ApplyEnv(standardEnv); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := settings.ApplyProfile(otherArgs.configPath, otherArgs.profile); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *otherArgs.showConfig {
+		for _, line := range settings.Report("secret-key") {
+			fmt.Println(line)
+		}
+		return
+	}
 	prefixStr := fmt.Sprintf("%s  ", time.Now().UTC().Format("2006-01-02 15:04:05")) + fmt.Sprintf("%-7s", "[INFO]")
 	if otherArgs.logFileDir != "" {
 		log.Printf("logfile dir: %s", otherArgs.logFileDir)
@@ -88,7 +202,8 @@ func main() {
 	}
 	log.SetFlags(0)
 	log.SetPrefix(prefixStr)
-	if flag.NFlag() == 0 {
+	// the environment and profiles set flags too, only the command line tells a bare run
+	if len(os.Args) == 1 {
 		log.Println("No arguments passed to nz_s3Connector. Below is the list of valid args: ")
 		flag.PrintDefaults()
 		os.Exit(1)
@@ -105,47 +220,283 @@ func main() {
 		log.Println("BackupsetID : ALL")
 	}
 	log.Println("Number of files to upload/download in parallel :", otherArgs.parallelJobs)
+	if *otherArgs.upload && *otherArgs.download {
+		log.Fatalf("Only one of -upload and -download can be set")
+	}
+	if *otherArgs.listLocal {
+		listLocalBackups(backupinfo)
+	}
+	if *otherArgs.upload && backupinfo.dirs != "" && otherArgs.stdinPath == "" {
+		detectLocalBackup(&backupinfo)
+	}
 	checkRequiredArguments(backupinfo, otherArgs)
-	cfg := conn.createS3Config()
+	cfg := conn.createS3Config(otherArgs.parallelJobs)
+	if *otherArgs.check {
+		conn.Check(cfg, otherArgs)
+	}
+	if *otherArgs.bench {
+		conn.Bench(cfg, backupinfo, otherArgs)
+	}
+	if backupinfo.backupsetID == nzbackup.BackupsetLatest || backupinfo.before != "" {
+		conn.resolveBackupset(cfg, &backupinfo, otherArgs)
+	}
+	if *otherArgs.list {
+		conn.List(cfg, backupinfo, otherArgs)
+	}
+	if *otherArgs.verify {
+		conn.Verify(cfg, backupinfo, otherArgs)
+	}
+	if *otherArgs.inspect {
+		conn.Inspect(cfg, backupinfo, otherArgs)
+	}
+	if otherArgs.diff != "" {
+		conn.Diff(cfg, backupinfo, otherArgs)
+	}
+	if *otherArgs.rehydrate {
+		conn.Rehydrate(cfg, backupinfo, otherArgs)
+		log.Println("Rehydration requested.")
+	}
+	if *otherArgs.rehydrateStatus {
+		conn.RehydrateStatus(cfg, backupinfo, otherArgs)
+	}
+	if *otherArgs.waitRehydrate {
+		conn.WaitForRehydration(cfg, backupinfo, otherArgs)
+	}
 	if *otherArgs.download {
 		conn.Download(cfg, backupinfo, otherArgs)
 		log.Println("Downloading complete.")
 	}
-	if *otherArgs.upload {
+	if *otherArgs.upload && otherArgs.stdinPath != "" {
+		conn.UploadStdin(cfg, backupinfo, otherArgs)
+		log.Println("Uploading complete.")
+	} else if *otherArgs.upload {
 		conn.Upload(cfg, backupinfo, otherArgs)
 		log.Println("Uploading complete.")
 	}
+	if otherArgs.copyTo != "" {
+		conn.Copy(cfg, backupinfo, otherArgs)
+		log.Println("Copying complete.")
+	}
+	if *otherArgs.delete {
+		conn.Delete(cfg, backupinfo, otherArgs)
+		log.Println("Deleting complete.")
+	}
+	if conn.metrics.Requests() > 0 {
+		log.Println("Connections:", conn.metrics)
+	}
+}
+
+func scanLocalBackups(bkp BackupInfo) *nzbackup.LocalBackups {
+	if bkp.dirs == "" {
+		log.Fatalf("Missing required field: dir is not found")
+	}
+	local, err := nzbackup.ScanLocal(strings.Split(bkp.dirs, " "))
+	if err != nil {
+		log.Fatalf("Error reading backup directory: %v", err)
+	}
+	return local
+}
+
+// listLocalBackups reports the backups found in the local directories.
+func listLocalBackups(bkp BackupInfo) {
+	for _, line := range scanLocalBackups(bkp).Report() {
+		log.Println(line)
+	}
+}
+
+// detectLocalBackup checks -npshost, -db and -backupset against the backups
+// found in the local directories and fills in the ones that are unambiguous.
+func detectLocalBackup(bkp *BackupInfo) {
+	host, db, backupset, err := scanLocalBackups(*bkp).Resolve(bkp.npshost, bkp.dbname, bkp.backupsetID)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if host != bkp.npshost {
+		log.Println("Detected Nps hostname :", host)
+	}
+	if db != bkp.dbname {
+		log.Println("Detected DB name :", db)
+	}
+	bkp.npshost, bkp.dbname, bkp.backupsetID = host, db, backupset
+}
+
+// validateLocalBackup refuses to upload a local backup that does not look
+// like complete nzbackup output.
+func validateLocalBackup(dirlist []string, bkp BackupInfo) {
+	problems, warnings, err := nzbackup.ValidateUpload(dirlist, bkp.npshost, bkp.dbname, bkp.backupsetID)
+	if err != nil {
+		log.Fatalf("Error reading backup directory: %v", err)
+	}
+	for _, warning := range warnings {
+		log.Printf("WARNING: %s", warning)
+	}
+	for _, problem := range problems {
+		log.Printf("ERROR: %s", problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("Local backup is incomplete, %d problems found. Use -skip-validation to upload it anyway.", len(problems))
+	}
+	log.Println("Local backup validated")
 }
 
 func checkRequiredArguments(bkp BackupInfo, arg OtherArgs) {
+	// the local directory is only needed when files are transferred
+	needDir := (*arg.upload && arg.stdinPath == "") || *arg.download
+	if arg.stdinPath != "" {
+		if !*arg.upload {
+			log.Fatalf("-stdin is only valid with -upload")
+		}
+		if bkp.npshost == "" || bkp.dbname == "" || bkp.backupsetID == "" {
+			log.Fatalf("Missing required field: npshost, db and backupset are required with -stdin")
+		}
+		if err := nzbackup.CheckStreamPath(arg.stdinPath); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
 	if bkp.backupsetID != "" {
-		if bkp.dirs == "" || bkp.npshost == "" || bkp.dbname == "" {
-			log.Fatalf("Missing required field: db, npshost or dir is not found")
+		if bkp.npshost == "" || bkp.dbname == "" {
+			log.Fatalf("Missing required field: db or npshost is not found")
 		}
 	} else if bkp.dbname != "" {
-		if bkp.dirs == "" || bkp.npshost == "" {
-			log.Fatalf("Missing required field: npshost or dir is not found")
+		if bkp.npshost == "" {
+			log.Fatalf("Missing required field: npshost is not found")
+		}
+	}
+	if bkp.restoreAsHost != "" || bkp.restoreAsDb != "" {
+		if !*arg.download {
+			log.Fatalf("-restore-as-host and -restore-as-db are only valid with -download")
 		}
-	} else if bkp.npshost != "" {
-		if bkp.dirs == "" {
-			log.Fatalf("Missing required field: dir is not found")
+		if bkp.restoreAsHost != "" && bkp.npshost == "" {
+			log.Fatalf("Missing required field: npshost is required with -restore-as-host")
 		}
-	} else {
-		if bkp.dirs == "" {
-			log.Fatalf("Missing required field: dir is not found")
+		if bkp.restoreAsDb != "" && bkp.dbname == "" {
+			log.Fatalf("Missing required field: db is required with -restore-as-db")
+		}
+		if strings.ContainsAny(bkp.restoreAsHost+bkp.restoreAsDb, "/\\") {
+			log.Fatalf("Invalid -restore-as-host or -restore-as-db, names must not contain path separators")
+		}
+	}
+	restorePoint := nzbackup.RestorePoint{Increment: bkp.increment, AsOf: bkp.asOf}
+	if !restorePoint.IsZero() {
+		if !*arg.download {
+			log.Fatalf("-increment and -as-of are only valid with -download")
+		}
+		if bkp.backupsetID == "" && bkp.before == "" {
+			log.Fatalf("Missing required field: backupset is required with -increment or -as-of")
+		}
+		if err := restorePoint.Check(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if *arg.fifo && !*arg.download {
+		log.Fatalf("-fifo is only valid with -download")
+	}
+	if bkp.tables != "" && !*arg.download {
+		log.Fatalf("-tables is only valid with -download")
+	}
+	if needDir && bkp.dirs == "" {
+		log.Fatalf("Missing required field: dir is not found")
+	}
+	if *arg.rehydrate {
+		switch types.Tier(arg.rehydrateTier) {
+		case types.TierExpedited, types.TierStandard, types.TierBulk:
+		default:
+			log.Fatalf("Invalid rehydrate tier %s. Valid values are Expedited, Standard and Bulk", arg.rehydrateTier)
 		}
+		if arg.rehydrateDays < 1 {
+			log.Fatalf("Invalid rehydrate days %d. It must be at least 1", arg.rehydrateDays)
+		}
+	}
+	if *arg.waitRehydrate && arg.pollInterval <= 0 {
+		log.Fatalf("Invalid poll interval %d. It must be a positive number of minutes", arg.pollInterval)
 	}
 
-	if *arg.upload || *arg.download {
+	if bkp.backupsetID == nzbackup.BackupsetLatest || bkp.before != "" {
+		if *arg.upload {
+			log.Fatalf("-backupset latest and -before are not valid with -upload")
+		}
+		if bkp.npshost == "" || bkp.dbname == "" {
+			log.Fatalf("Missing required field: db or npshost is not found. They are required to resolve the backupset")
+		}
+		if bkp.before != "" && bkp.backupsetID != "" && bkp.backupsetID != nzbackup.BackupsetLatest {
+			log.Fatalf("Only one of -backupset and -before can be set")
+		}
+		if bkp.before != "" {
+			if _, err := nzbackup.ParseTimestamp(bkp.before); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+	}
+	if *arg.inspect && bkp.backupsetID == "" && bkp.before == "" {
+		log.Fatalf("Missing required field: backupset is required with -inspect")
+	}
+	if arg.diff != "" && (bkp.npshost == "" || bkp.dbname == "") {
+		log.Fatalf("Missing required field: db or npshost is not found. They are required with -diff")
+	}
+	if (*arg.verify || *arg.delete || arg.copyTo != "") && (bkp.npshost == "" || bkp.dbname == "" || (bkp.backupsetID == "" && bkp.before == "")) {
+		log.Fatalf("Missing required field: npshost, db and backupset are required with -verify, -delete and -copy-to")
+	}
+	if arg.copyTo != "" && arg.copyTo == arg.uniqueId {
+		log.Fatalf("-copy-to must name a unique-id other than -unique-id")
+	}
+	if *arg.upload || *arg.download || *arg.rehydrate || *arg.rehydrateStatus || *arg.waitRehydrate || *arg.inspect || arg.diff != "" ||
+		*arg.list || *arg.verify || *arg.delete || arg.copyTo != "" {
 		if arg.uniqueId == "" {
-			log.Fatalf("Missing required field: uniqueid is not found. It is required for upload/download operation")
+			log.Fatalf("Missing required field: uniqueid is not found. It is required for upload/download/rehydrate/inspect/diff/list/verify/delete/copy operation")
+		}
+	}
+}
+
+// resolveBackupset replaces -backupset latest or -before with the ID of the
+// newest matching backupset in the bucket. With -before, only the increments
+// completed by then are downloaded unless -increment or -as-of is set.
+func (s3Conn *S3Conn) resolveBackupset(cfg aws.Config, bkp *BackupInfo, otherArgs OtherArgs) {
+	dbpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname)
+	var relpaths []string
+	s3Conn.listBackupObjects(s3Conn.client, dbpath, func(obj types.Object) {
+		relpath, err := filepath.Rel(otherArgs.uniqueId, *obj.Key)
+		if err != nil {
+			log.Fatalf("Error in fetching relative path: %v", err)
 		}
+		relpaths = append(relpaths, relpath)
+	})
+
+	var before time.Time
+	if bkp.before != "" {
+		before, _ = nzbackup.ParseTimestamp(bkp.before)
+	}
+	id, err := nzbackup.ResolveBackupset(relpaths, before, *otherArgs.allowIncomplete)
+	if err != nil {
+		log.Fatalf("Unable to resolve backupset under %s. Err: %v", dbpath, err)
+	}
+	log.Println("Resolved BackupsetID :", id)
+	bkp.backupsetID = id
+	if bkp.before != "" && bkp.increment == 0 && bkp.asOf == "" {
+		bkp.asOf = bkp.before
 	}
 }
 
 func (s3Conn *S3Conn) Upload(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	err := s3Conn.prepareObjectLock(cfg, otherArgs)
+	if err != nil {
+		log.Fatalf("Cannot upload with the requested object lock settings: %v", err)
+	}
+
 	dirlist := strings.Split(bkp.dirs, " ")
-	for _, dir := range dirlist {
+	if !*otherArgs.skipValidation {
+		validateLocalBackup(dirlist, bkp)
+	}
+	// a file too large for the part limits fails here rather than after hours of uploading
+	err = nzbackup.S3Limits.CheckPartSizes(dirlist, s3Conn.blockSize*1024*1024, s3Conn.minBlockSize*1024*1024, s3Conn.maxBlockSize*1024*1024)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// files are collected to write the commit object of every backupset once all of them are uploaded
+	var uploaded nzbackup.UploadSet
+	for i, dir := range dirlist {
+		// location number of this directory in a backup striped across several directories
+		location := i + 1
 		backupdir := filepath.Join(dir, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
 		_, err := os.Stat(backupdir)
 		if err != nil {
@@ -160,6 +511,9 @@ func (s3Conn *S3Conn) Upload(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs
 		// buffered channel to limit concurrency
 		sem := make(chan struct{}, otherArgs.parallelJobs)
 		err = filepath.Walk(backupdir, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
 			if info.IsDir() {
 				return nil
 			}
@@ -168,24 +522,39 @@ func (s3Conn *S3Conn) Upload(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs
 			if patherr != nil {
 				return patherr
 			}
+			relfilepath = nzbackup.LocationPath(relfilepath, location)
 
 			wg.Add(1)
 			sem <- struct{}{}
 
+			// named pipes written by nzbackup are uploaded while they are written
+			stream := nzbackup.IsStream(info)
+			mode := info.Mode()
 			go func() {
-				err := s3Conn.uploadFileToS3(path, cfg, otherArgs.uniqueId, relfilepath)
+				var file nzbackup.ManifestFile
+				var err error
+				if stream {
+					file, err = s3Conn.uploadStreamToS3(path, mode, cfg, otherArgs.uniqueId, relfilepath, *otherArgs.checksum)
+				} else {
+					file, err = nzbackup.DescribeFile(path, *otherArgs.checksum)
+					if err != nil {
+						log.Fatalf("Failed to read file %s. Err: %v", path, err)
+					}
+					err = s3Conn.uploadFileToS3(path, cfg, otherArgs.uniqueId, relfilepath)
+				}
 				if err != nil {
 					log.Println("Error while uploading file. Ensure aws s3 access-key-id, secret-access-key, bucket_url are correct.")
 					log.Fatalf("Failed to upload file. Err: %v", err)
 				}
 				log.Printf("File %s uploaded successfully", path)
+				uploaded.Add(relfilepath, file)
 				mu.Lock()
 				filesuploaded++
 				mu.Unlock()
 				wg.Done()
 				<-sem
 			}()
-			return err
+			return nil
 		})
 		if err != nil {
 			log.Fatalf("Encountered error while traversing the directory. Err: %v", err)
@@ -193,104 +562,300 @@ func (s3Conn *S3Conn) Upload(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs
 		wg.Wait()
 		log.Printf("Total files uploaded: %d", filesuploaded)
 	}
+	s3Conn.uploadCommits(cfg, otherArgs.uniqueId, &uploaded)
+}
+
+// partSize returns the part size in bytes for an object of size bytes, or of
+// unknown size when size is below 0, within the s3 part count limit.
+func (s3Conn *S3Conn) partSize(size int64) (int64, error) {
+	return nzbackup.S3Limits.PartSize(size, s3Conn.blockSize*1024*1024, s3Conn.minBlockSize*1024*1024, s3Conn.maxBlockSize*1024*1024)
 }
 
-func (s3Conn *S3Conn) getUploader(cfg aws.Config) *manager.Uploader {
-	return manager.NewUploader(s3.NewFromConfig(cfg), func(u *manager.Uploader) {
-		u.PartSize = s3Conn.blockSize * 1024 * 1024
+// getUploader returns an uploader on the shared client for an object of size
+// bytes, or of unknown size when size is below 0. Objects smaller than a part
+// are uploaded with a single request.
+func (s3Conn *S3Conn) getUploader(cfg aws.Config, size int64) (*manager.Uploader, error) {
+	partSize, err := s3Conn.partSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return manager.NewUploader(s3Conn.client, func(u *manager.Uploader) {
+		u.PartSize = partSize
 		u.Concurrency = int(s3Conn.streams)
-	})
+	}), nil
 }
 
 func (s3Conn *S3Conn) uploadFileToS3(absFilePath string, cfg aws.Config, uniqueId string, relFilePath string) error {
-	uploader := s3Conn.getUploader(cfg)
 	f, err := os.Open(absFilePath)
 	if err != nil {
 		log.Printf("Unable to open file %s. Err: %v", absFilePath, err)
 		return err
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Printf("Unable to stat file %s. Err: %v", absFilePath, err)
+		return err
+	}
+	uploader, err := s3Conn.getUploader(cfg, info.Size())
+	if err != nil {
+		log.Fatalf("Cannot upload file: %s. Err: %v", absFilePath, err)
+	}
 
-	_, err = uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(s3Conn.bucketUrl),
-		Body:   f,
-		Key:    aws.String(filepath.Join(uniqueId, relFilePath)),
-	})
+	_, err = uploader.Upload(context.TODO(), s3Conn.putObjectInput(f, uniqueId, relFilePath))
 	if err != nil {
 		log.Fatalf("Failed to upload file: %s. Err: %v", absFilePath, err)
 	}
 	return nil
 }
 
+// uploadStreamToS3 uploads a named pipe as it is written, in parts of the
+// block size, and returns its manifest entry once the writer has closed it.
+func (s3Conn *S3Conn) uploadStreamToS3(absFilePath string, mode os.FileMode, cfg aws.Config, uniqueId string, relFilePath string, checksum bool) (nzbackup.ManifestFile, error) {
+	// opening blocks until nzbackup opens the pipe for writing
+	f, err := os.Open(absFilePath)
+	if err != nil {
+		log.Printf("Unable to open stream %s. Err: %v", absFilePath, err)
+		return nzbackup.ManifestFile{}, err
+	}
+	defer f.Close()
+	return s3Conn.uploadStream(f, mode, cfg, uniqueId, relFilePath, checksum)
+}
+
+// uploadStream uploads a reader of unknown length. The uploader reads it part
+// by part, so only streams*blocksize MB of it are held in memory.
+func (s3Conn *S3Conn) uploadStream(r io.Reader, mode os.FileMode, cfg aws.Config, uniqueId string, relFilePath string, checksum bool) (nzbackup.ManifestFile, error) {
+	stream := nzbackup.NewStreamReader(r, mode, checksum)
+	uploader, err := s3Conn.getUploader(cfg, -1)
+	if err != nil {
+		return nzbackup.ManifestFile{}, err
+	}
+	_, err = uploader.Upload(context.TODO(), s3Conn.putObjectInput(stream, uniqueId, relFilePath))
+	if err != nil {
+		return nzbackup.ManifestFile{}, err
+	}
+	return stream.File(), nil
+}
+
+// UploadStdin uploads the standard input as a single file of the backupset,
+// for nzbackup output piped into the connector. No commit object is written,
+// as the connector cannot know when the backupset is complete.
+func (s3Conn *S3Conn) UploadStdin(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	err := s3Conn.prepareObjectLock(cfg, otherArgs)
+	if err != nil {
+		log.Fatalf("Cannot upload with the requested object lock settings: %v", err)
+	}
+	relfilepath := filepath.Join("Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID, otherArgs.stdinPath)
+	log.Printf("Uploading standard input to s3 bucket %s as %s", s3Conn.bucketUrl, filepath.Join(otherArgs.uniqueId, relfilepath))
+	file, err := s3Conn.uploadStream(os.Stdin, 0644, cfg, otherArgs.uniqueId, relfilepath, *otherArgs.checksum)
+	if err != nil {
+		log.Println("Error while uploading file. Ensure aws s3 access-key-id, secret-access-key, bucket_url are correct.")
+		log.Fatalf("Failed to upload standard input. Err: %v", err)
+	}
+	log.Printf("Standard input uploaded successfully, %d bytes", file.Size)
+	if file.SHA256 != "" {
+		log.Printf("SHA-256 checksum: %s", file.SHA256)
+	}
+}
+
+// putObjectInput describes the upload of a backup file with the object lock,
+// metadata and tags requested for this run.
+func (s3Conn *S3Conn) putObjectInput(body io.Reader, uniqueId string, relFilePath string) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Body:   body,
+		Key:    aws.String(filepath.Join(uniqueId, relFilePath)),
+	}
+	s3Conn.applyObjectLock(input)
+	input.Metadata = nzbackup.ObjectMetadata(relFilePath, time.Now())
+	if s3Conn.objectTags {
+		input.Tagging = aws.String(objectTagging(input.Metadata))
+	}
+	return input
+}
+
+// objectTagging encodes tags in the URL query format expected by PutObject.
+func objectTagging(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
 func (s3Conn *S3Conn) Download(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
 	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
 	log.Printf("Backup dir path: %s", bkpath)
 	dirlist := strings.Split(bkp.dirs, " ")
 
-	for _, dir := range dirlist {
-		log.Printf("Downloading data to dir %s", dir)
-		client := s3.NewFromConfig(cfg)
-		filesdownloaded := 0
-		var wg sync.WaitGroup
-		var mu sync.Mutex
+	client := s3Conn.client
+	keys, modtimes, manifest := s3Conn.selectBackupKeys(client, otherArgs, bkpath)
 
-		// buffered channel to limit concurrency
-		sem := make(chan struct{}, otherArgs.parallelJobs)
+	relpaths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		relpath, err := filepath.Rel(otherArgs.uniqueId, key)
+		if err != nil {
+			log.Fatalf("Error in fetching download relative path: %v", err)
+		}
+		relpaths = append(relpaths, relpath)
+	}
+	restorePoint := nzbackup.RestorePoint{Increment: bkp.increment, AsOf: bkp.asOf}
+	if !restorePoint.IsZero() {
+		keys, relpaths = selectRestorePoint(restorePoint, keys, relpaths, modtimes)
+	}
+	if bkp.tables != "" {
+		keys, relpaths = s3Conn.selectTables(client, bkp.tables, otherArgs.uniqueId, keys, relpaths)
+	}
+	// every location of the backup is restored into its own directory
+	rename := nzbackup.Rename{NpsHost: bkp.restoreAsHost, Database: bkp.restoreAsDb}
+	placement, err := nzbackup.NewPlacement(dirlist, relpaths, rename)
+	if err != nil {
+		log.Fatalf("Unable to place the backup files in %s. Err: %v", dirlist, err)
+	}
+	if placement.Locations() != len(dirlist) {
+		log.Printf("Backup has %d locations, restoring them into %d directories", placement.Locations(), len(dirlist))
+	}
+	if !rename.IsZero() {
+		log.Printf("Restoring backup as %s", rename.Path(path.Join("Netezza", bkp.npshost, bkp.dbname)))
+	}
 
-		paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
-			Bucket: aws.String(s3Conn.bucketUrl),
-			Prefix: aws.String(otherArgs.uniqueId),
-		})
+	log.Printf("Downloading data to dirs %s", dirlist)
+	// local files that may need a fixup before nzrestore accepts them
+	var metadatafiles []string
+	// with -fifo, data files are streamed once the other files are downloaded
+	var fifos []fifoFile
+	streamed := make(map[string]bool)
+	filesdownloaded := 0
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	// buffered channel to limit concurrency
+	sem := make(chan struct{}, otherArgs.parallelJobs)
+
+	for i, key := range keys {
+		outfilepath, ok := placement.LocalPath(relpaths[i])
+		if !ok {
+			log.Printf("Skipping %s, a file with the same path was downloaded from a lower location", key)
+			continue
+		}
+
+		err := os.MkdirAll(filepath.Dir(outfilepath), 0777)
+		if err != nil {
+			log.Fatalf("Error in creating backup directory: %v", err)
+		}
+		if nzbackup.NeedsFixup(relpaths[i], rename) {
+			metadatafiles = append(metadatafiles, outfilepath)
+		}
+		if *otherArgs.fifo && nzbackup.IsStreamable(relpaths[i]) {
+			fifos = append(fifos, fifoFile{key: key, relpath: relpaths[i], localpath: outfilepath})
+			streamed[outfilepath] = true
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
 
-		for paginator.HasMorePages() {
-			page, err := paginator.NextPage(context.TODO())
+		go func() {
+			err := s3Conn.downloadFileFromS3(outfilepath, cfg, key)
 			if err != nil {
-				log.Fatalf("Error while listing objects: %v", err)
+				log.Println("Error while downloading file. Ensure aws s3 access-key-id, secret-access-key, bucket_url are correct.")
+				log.Fatalf("Failed to download file. Err: %v", err)
 			}
+			log.Printf("File %s downloaded successfully", key)
+			mu.Lock()
+			filesdownloaded++
+			mu.Unlock()
+			wg.Done()
+			<-sem
+		}()
+	}
+	wg.Wait()
+	log.Printf("Total files downloaded: %d", filesdownloaded)
+	if manifest != nil {
+		relbkpath, _ := filepath.Rel(otherArgs.uniqueId, bkpath)
+		s3Conn.verifyDownload(manifest, func(p string) (string, bool) {
+			local, ok := placement.LocalPath(path.Join(relbkpath, p))
+			return local, ok && !streamed[local]
+		}, otherArgs)
+	}
+	warnings, err := nzbackup.FixupRestore(metadatafiles, placement)
+	if err != nil {
+		log.Fatalf("Failed to update backup metadata for restore. Err: %v", err)
+	}
+	for _, warning := range warnings {
+		log.Printf("WARNING: %s", warning)
+	}
+	if len(fifos) > 0 {
+		s3Conn.serveFifos(client, fifos, manifest, otherArgs)
+	}
+}
+
+// selectRestorePoint keeps the keys of the increments needed to restore up to the restore point.
+func selectRestorePoint(restorePoint nzbackup.RestorePoint, keys []string, relpaths []string, modtimes map[string]time.Time) ([]string, []string) {
+	files := make(map[string]time.Time, len(keys))
+	for i, key := range keys {
+		files[relpaths[i]] = modtimes[key]
+	}
+	chain, err := restorePoint.Chain(files)
+	if err != nil {
+		log.Fatalf("Unable to select increments to download. Err: %v", err)
+	}
+	log.Printf("Downloading increments %v", chain)
 
-			for _, obj := range page.Contents {
-				key := *obj.Key
+	var selectedKeys, selectedRelpaths []string
+	for i, relpath := range relpaths {
+		if nzbackup.InChain(relpath, chain) {
+			selectedKeys = append(selectedKeys, keys[i])
+			selectedRelpaths = append(selectedRelpaths, relpath)
+		}
+	}
+	return selectedKeys, selectedRelpaths
+}
 
-				if strings.HasPrefix(key, bkpath) {
-					splitdir, filename := filepath.Split(key)
-					relfilepath, err := filepath.Rel(otherArgs.uniqueId, splitdir)
-					if err != nil {
-						log.Fatalf("Error in fetching download relative path: %v", err)
-					}
+// listBackupObjects calls fn for every object stored under bkpath, the cloud path of the selected backup.
+// Only the objects under bkpath are listed, the trailing separator keeping names sharing a prefix apart.
+func (s3Conn *S3Conn) listBackupObjects(client *s3.Client, bkpath string, fn func(obj types.Object)) {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Prefix: aws.String(bkpath + "/"),
+	})
 
-					dumpdir := filepath.Join(dir, relfilepath)
-					err = os.MkdirAll(dumpdir, 0777)
-					if err != nil {
-						log.Fatalf("Error in creating backup directory: %v", err)
-					}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Fatalf("Error while listing objects: %v", err)
+		}
 
-					outfilepath := path.Join(dumpdir, filename)
-					wg.Add(1)
-					sem <- struct{}{}
-
-					go func() {
-						err := s3Conn.downloadFileFromS3(outfilepath, cfg, key)
-						if err != nil {
-							log.Println("Error while downloading file. Ensure aws s3 access-key-id, secret-access-key, bucket_url are correct.")
-							log.Fatalf("Failed to download file. Err: %v", err)
-						}
-						log.Printf("File %s downloaded successfully", key)
-						mu.Lock()
-						filesdownloaded++
-						mu.Unlock()
-						wg.Done()
-						<-sem
-					}()
-				}
-			}
+		for _, obj := range page.Contents {
+			fn(obj)
+		}
+	}
+}
+
+// listSubdirs returns the directories directly under dir, from a listing
+// delimited by the separator that does not return the objects below them.
+func (s3Conn *S3Conn) listSubdirs(client *s3.Client, dir string) []string {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s3Conn.bucketUrl),
+		Prefix:    aws.String(dir + "/"),
+		Delimiter: aws.String("/"),
+	})
+
+	var subdirs []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Fatalf("Error while listing directories under %s: %v", dir, err)
+		}
+		for _, prefix := range page.CommonPrefixes {
+			subdirs = append(subdirs, strings.TrimSuffix(aws.ToString(prefix.Prefix), "/"))
 		}
-		wg.Wait()
-		log.Printf("Total files downloaded: %d", filesdownloaded)
 	}
+	return subdirs
 }
 
 func (s3Conn *S3Conn) getDownloader(cfg aws.Config) *manager.Downloader {
-	return manager.NewDownloader(s3.NewFromConfig(cfg), func(d *manager.Downloader) {
+	return manager.NewDownloader(s3Conn.client, func(d *manager.Downloader) {
 		d.PartSize = s3Conn.blockSize * 1024 * 1024
 		d.Concurrency = int(s3Conn.streams)
 	})
@@ -316,7 +881,10 @@ func (s3Conn *S3Conn) downloadFileFromS3(absFilePath string, cfg aws.Config, rel
 	return nil
 }
 
-func (s3Conn *S3Conn) createS3Config() aws.Config {
+// createS3Config loads the AWS configuration and creates the client shared by
+// the run, with a connection pool sized for parallelJobs files of streams
+// parts each.
+func (s3Conn *S3Conn) createS3Config(parallelJobs int64) aws.Config {
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(s3Conn.defaultRegion),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
@@ -334,6 +902,9 @@ func (s3Conn *S3Conn) createS3Config() aws.Config {
 	if s3Conn.endPoint != "" {
 		cfg.BaseEndpoint = aws.String(s3Conn.endPoint)
 	}
+	s3Conn.metrics = new(nzbackup.ConnMetrics)
+	cfg.HTTPClient = nzbackup.NewHTTPClient(int(parallelJobs*s3Conn.streams), s3Conn.http2, s3Conn.metrics)
+	s3Conn.client = s3.NewFromConfig(cfg)
 
 	return cfg
 }
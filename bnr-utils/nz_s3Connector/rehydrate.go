@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type restoreState int
+
+const (
+	// object can be downloaded right away
+	objectReadable restoreState = iota
+	// object sits in an archive tier and no restore was requested yet
+	objectArchived
+	// restore was requested but the object is not readable yet
+	objectRestoring
+)
+
+type rehydrateCounts struct {
+	readable  int
+	archived  int
+	restoring int
+}
+
+func (c rehydrateCounts) pending() int {
+	return c.archived + c.restoring
+}
+
+// isArchiveStorageClass reports whether objects of the given storage class
+// may need a restore before GetObject succeeds.
+func isArchiveStorageClass(class types.ObjectStorageClass) bool {
+	switch class {
+	case types.ObjectStorageClassGlacier, types.ObjectStorageClassDeepArchive, types.ObjectStorageClassIntelligentTiering:
+		return true
+	}
+	return false
+}
+
+func (s3Conn *S3Conn) objectRestoreState(client *s3.Client, obj types.Object) (restoreState, error) {
+	if !isArchiveStorageClass(obj.StorageClass) {
+		return objectReadable, nil
+	}
+
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(s3Conn.bucketUrl),
+		Key:    obj.Key,
+	})
+	if err != nil {
+		return objectReadable, err
+	}
+
+	// intelligent tiering objects are only archived when the archive status is set
+	if obj.StorageClass == types.ObjectStorageClassIntelligentTiering && head.ArchiveStatus == "" {
+		return objectReadable, nil
+	}
+	if head.Restore == nil {
+		return objectArchived, nil
+	}
+	if strings.Contains(*head.Restore, `ongoing-request="true"`) {
+		return objectRestoring, nil
+	}
+	return objectReadable, nil
+}
+
+// forEachRestoreState looks up the restore state of every object of the backup
+// in parallel and calls fn with it. fn may be called from several goroutines.
+func (s3Conn *S3Conn) forEachRestoreState(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs, fn func(client *s3.Client, obj types.Object, state restoreState)) {
+	bkpath := filepath.Join(otherArgs.uniqueId, "Netezza", bkp.npshost, bkp.dbname, bkp.backupsetID)
+	client := s3Conn.client
+	var wg sync.WaitGroup
+
+	// buffered channel to limit concurrency
+	sem := make(chan struct{}, otherArgs.parallelJobs)
+	s3Conn.listBackupObjects(client, bkpath, func(obj types.Object) {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			state, err := s3Conn.objectRestoreState(client, obj)
+			if err != nil {
+				log.Fatalf("Failed to get restore status of object %s. Err: %v", *obj.Key, err)
+			}
+			fn(client, obj, state)
+			wg.Done()
+			<-sem
+		}()
+	})
+	wg.Wait()
+}
+
+func (s3Conn *S3Conn) getRehydrateCounts(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) rehydrateCounts {
+	var counts rehydrateCounts
+	var mu sync.Mutex
+
+	s3Conn.forEachRestoreState(cfg, bkp, otherArgs, func(client *s3.Client, obj types.Object, state restoreState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case objectReadable:
+			counts.readable++
+		case objectArchived:
+			counts.archived++
+		case objectRestoring:
+			counts.restoring++
+		}
+	})
+	return counts
+}
+
+func (s3Conn *S3Conn) restoreObject(client *s3.Client, obj types.Object, otherArgs OtherArgs) error {
+	request := &types.RestoreRequest{}
+	// intelligent tiering objects are moved back to the access tier and do not take a retention period or tier
+	if obj.StorageClass != types.ObjectStorageClassIntelligentTiering {
+		request.Days = aws.Int32(int32(otherArgs.rehydrateDays))
+		request.GlacierJobParameters = &types.GlacierJobParameters{Tier: types.Tier(otherArgs.rehydrateTier)}
+	}
+
+	_, err := client.RestoreObject(context.TODO(), &s3.RestoreObjectInput{
+		Bucket:         aws.String(s3Conn.bucketUrl),
+		Key:            obj.Key,
+		RestoreRequest: request,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// Rehydrate requests a restore for every archived object of the selected backup.
+func (s3Conn *S3Conn) Rehydrate(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	log.Printf("Requesting restore of archived objects in s3 bucket %s with unique-id %s, tier %s for %d days", s3Conn.bucketUrl, otherArgs.uniqueId, otherArgs.rehydrateTier, otherArgs.rehydrateDays)
+	var counts rehydrateCounts
+	var mu sync.Mutex
+
+	s3Conn.forEachRestoreState(cfg, bkp, otherArgs, func(client *s3.Client, obj types.Object, state restoreState) {
+		if state == objectArchived {
+			err := s3Conn.restoreObject(client, obj, otherArgs)
+			if err != nil {
+				log.Fatalf("Failed to request restore of object %s. Err: %v", *obj.Key, err)
+			}
+			log.Printf("Restore requested for object %s", *obj.Key)
+			state = objectRestoring
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case objectReadable:
+			counts.readable++
+		case objectRestoring:
+			counts.restoring++
+		}
+	})
+	log.Printf("Objects already readable: %d, restore in progress: %d", counts.readable, counts.restoring)
+}
+
+// RehydrateStatus reports how many objects of the selected backup are not readable yet.
+func (s3Conn *S3Conn) RehydrateStatus(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	counts := s3Conn.getRehydrateCounts(cfg, bkp, otherArgs)
+	log.Printf("Objects readable: %d, restore in progress: %d, archived without restore request: %d", counts.readable, counts.restoring, counts.archived)
+	log.Printf("Objects pending: %d", counts.pending())
+}
+
+// WaitForRehydration polls the restore state of the selected backup until every object is readable.
+func (s3Conn *S3Conn) WaitForRehydration(cfg aws.Config, bkp BackupInfo, otherArgs OtherArgs) {
+	for {
+		counts := s3Conn.getRehydrateCounts(cfg, bkp, otherArgs)
+		if counts.pending() == 0 {
+			log.Printf("All %d objects are readable", counts.readable)
+			return
+		}
+		if counts.archived > 0 {
+			log.Fatalf("%d objects are archived and no restore was requested for them. Run with -rehydrate first.", counts.archived)
+		}
+		log.Printf("Waiting for %d objects to be restored, checking again in %d minutes", counts.pending(), otherArgs.pollInterval)
+		time.Sleep(time.Duration(otherArgs.pollInterval) * time.Minute)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"netezza-utils/bnr-utils/nzbackup"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// selectTables reads the schema.xml of every increment and keeps the keys
+// needed to restore the given tables: the metadata plus their data files.
+func (s3Conn *S3Conn) selectTables(client *s3.Client, tables string, uniqueId string, keys []string, relpaths []string) ([]string, []string) {
+	filter, err := nzbackup.ParseTableFilter(tables)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	objids, matched, err := filter.Resolve(relpaths, func(relpath string) ([]byte, error) {
+		data, found, err := s3Conn.readObject(client, filepath.Join(uniqueId, relpath))
+		if err == nil && !found {
+			err = fmt.Errorf("%s not found", relpath)
+		}
+		return data, err
+	})
+	if err != nil {
+		log.Fatalf("Unable to select tables to download. Err: %v", err)
+	}
+	for _, table := range matched {
+		log.Printf("Downloading table %s (objid %s)", table, table.ObjID)
+	}
+
+	var selectedKeys, selectedRelpaths []string
+	for i, relpath := range relpaths {
+		if nzbackup.KeepForTables(relpath, objids) {
+			selectedKeys = append(selectedKeys, keys[i])
+			selectedRelpaths = append(selectedRelpaths, relpath)
+		}
+	}
+	log.Printf("Downloading %d of %d files for %d tables", len(selectedKeys), len(keys), len(matched))
+	return selectedKeys, selectedRelpaths
+}
@@ -0,0 +1,204 @@
+package nzbackup
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BenchDir is the directory of the scratch objects written by -bench, outside
+// of the Netezza tree so that listings of backups never see them.
+const BenchDir = ".nzbench"
+
+// DefaultBenchMatrix is the matrix of settings -bench tries when no
+// -bench-matrix is given.
+const DefaultBenchMatrix = "streams=8,16,32 blocksize=25,100 paralleljobs=2,6"
+
+// BenchPath returns a scratch directory for -bench, relative to the unique
+// id, that does not collide with concurrent runs.
+func BenchPath() string {
+	return BenchDir + "/" + scratchName()
+}
+
+// BenchSetting is one combination of transfer settings tried by -bench.
+type BenchSetting struct {
+	Streams      int
+	BlockSize    int // MB
+	ParallelJobs int
+}
+
+func (s BenchSetting) String() string {
+	return fmt.Sprintf("-streams %d -blocksize %d -paralleljobs %d", s.Streams, s.BlockSize, s.ParallelJobs)
+}
+
+// ParseBenchMatrix returns every combination of the values given for streams,
+// blocksize and paralleljobs, as in "streams=8,16 blocksize=25,100". A
+// setting that is not given keeps its value in def.
+func ParseBenchMatrix(spec string, def BenchSetting) ([]BenchSetting, error) {
+	values := map[string][]int{
+		"streams":      {def.Streams},
+		"blocksize":    {def.BlockSize},
+		"paralleljobs": {def.ParallelJobs},
+	}
+	for _, field := range strings.Fields(strings.ReplaceAll(spec, ";", " ")) {
+		name, list, ok := strings.Cut(field, "=")
+		if _, known := values[name]; !ok || !known {
+			return nil, fmt.Errorf("Invalid -bench-matrix entry %q, use streams=N,N blocksize=N,N paralleljobs=N,N", field)
+		}
+		var ints []int
+		for _, v := range strings.Split(list, ",") {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("Invalid %s value %q in -bench-matrix, expected a positive number", name, v)
+			}
+			ints = append(ints, n)
+		}
+		values[name] = ints
+	}
+	var matrix []BenchSetting
+	for _, streams := range values["streams"] {
+		for _, blocksize := range values["blocksize"] {
+			for _, jobs := range values["paralleljobs"] {
+				matrix = append(matrix, BenchSetting{Streams: streams, BlockSize: blocksize, ParallelJobs: jobs})
+			}
+		}
+	}
+	return matrix, nil
+}
+
+// MaxParallelJobs returns the largest paralleljobs of a matrix, which is the
+// number of files -bench needs.
+func MaxParallelJobs(matrix []BenchSetting) int {
+	n := 0
+	for _, s := range matrix {
+		n = max(n, s.ParallelJobs)
+	}
+	return n
+}
+
+// WriteBenchFiles writes n files of size bytes of random data to dir, so that
+// neither compression nor deduplication flatter the results.
+func WriteBenchFiles(dir string, n int, size int64) ([]string, error) {
+	var paths []string
+	rng := rand.NewChaCha8([32]byte{})
+	for i := 0; i < n; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("bench%03d.dat", i))
+		f, err := os.Create(p)
+		if err != nil {
+			return nil, err
+		}
+		w := bufio.NewWriterSize(f, 1024*1024)
+		buf := make([]byte, 64*1024)
+		for written := int64(0); written < size; {
+			chunk := buf[:min(int64(len(buf)), size-written)]
+			rng.Read(chunk)
+			if _, err := w.Write(chunk); err != nil {
+				f.Close()
+				return nil, err
+			}
+			written += int64(len(chunk))
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// MemorySampler records the peak heap in use while a transfer runs.
+type MemorySampler struct {
+	stop chan struct{}
+	done sync.WaitGroup
+	peak uint64
+}
+
+// SampleMemory starts sampling the heap every 100ms.
+func SampleMemory() *MemorySampler {
+	runtime.GC()
+	m := &MemorySampler{stop: make(chan struct{})}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			m.peak = max(m.peak, stats.HeapInuse)
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return m
+}
+
+// Stop ends the sampling and returns the peak heap in use in bytes.
+func (m *MemorySampler) Stop() uint64 {
+	close(m.stop)
+	m.done.Wait()
+	return m.peak
+}
+
+// BenchResult is the outcome of one setting of -bench.
+type BenchResult struct {
+	Setting          BenchSetting
+	Bytes            int64 // transferred in each direction
+	Upload           time.Duration
+	Download         time.Duration
+	UploadRequests   int64
+	DownloadRequests int64
+	PeakMemory       uint64
+}
+
+func mbps(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / (1024 * 1024) / d.Seconds()
+}
+
+// Throughput returns the upload and download rates in MB/s.
+func (r BenchResult) Throughput() (upload float64, download float64) {
+	return mbps(r.Bytes, r.Upload), mbps(r.Bytes, r.Download)
+}
+
+// combined is the rate of a round trip, which weighs upload and download
+// alike.
+func (r BenchResult) combined() float64 {
+	return mbps(2*r.Bytes, r.Upload+r.Download)
+}
+
+func (r BenchResult) String() string {
+	up, down := r.Throughput()
+	return fmt.Sprintf("%-48s upload %8.1f MB/s %6d requests   download %8.1f MB/s %6d requests   peak heap %s",
+		r.Setting, up, r.UploadRequests, down, r.DownloadRequests, FormatSize(int64(r.PeakMemory)))
+}
+
+// RecommendBench returns the result with the best round trip throughput.
+func RecommendBench(results []BenchResult) (BenchResult, bool) {
+	if len(results) == 0 {
+		return BenchResult{}, false
+	}
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.combined() > best.combined() {
+			best = r
+		}
+	}
+	return best, true
+}
@@ -0,0 +1,146 @@
+package nzbackup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupsetSummary describes a backupset stored in the cloud from the object
+// listing alone.
+type BackupsetSummary struct {
+	NpsHost     string
+	Database    string
+	BackupsetID string
+	Files       int
+	Size        int64
+	Committed   bool
+	// Manifested is set when the backupset has a manifest. Backupsets with
+	// neither a manifest nor a commit object were uploaded by older connectors.
+	Manifested bool
+	// LastModified is the time the newest object of the backupset was written.
+	LastModified time.Time
+}
+
+// ListBackupsets groups the listed objects, including the commit and manifest
+// objects, by backupset.
+func ListBackupsets(objects []ObjectInfo) []BackupsetSummary {
+	byDir := make(map[string]*BackupsetSummary)
+	for _, obj := range objects {
+		bsdir, ok := BackupsetDir(obj.Path)
+		if !ok {
+			continue
+		}
+		s := byDir[bsdir]
+		if s == nil {
+			info := ParsePath(obj.Path)
+			s = &BackupsetSummary{NpsHost: info.NpsHost, Database: info.Database, BackupsetID: info.BackupsetID}
+			byDir[bsdir] = s
+		}
+		if obj.ModTime.After(s.LastModified) {
+			s.LastModified = obj.ModTime
+		}
+		if IsCommitPath(obj.Path) {
+			s.Committed = true
+		}
+		if IsManifestPath(obj.Path) {
+			s.Manifested = true
+		}
+		if IsControlPath(obj.Path) {
+			continue
+		}
+		s.Files++
+		s.Size += obj.Size
+	}
+
+	summaries := make([]BackupsetSummary, 0, len(byDir))
+	for _, bsdir := range sortedKeys(byDir) {
+		summaries = append(summaries, *byDir[bsdir])
+	}
+	return summaries
+}
+
+func (s BackupsetSummary) String() string {
+	state := "committed"
+	if !s.Committed && !s.Manifested {
+		state = "legacy, not checked"
+	} else if !s.Committed {
+		state = "incomplete"
+	}
+	return fmt.Sprintf("%s/%s/%s: %d files, %s, %s, last written %s", s.NpsHost, s.Database, s.BackupsetID,
+		s.Files, FormatSize(s.Size), state, s.LastModified.UTC().Format(time.RFC3339))
+}
+
+// VerifyBackupset checks a backupset stored in the cloud without downloading
+// it: it must have a commit object, and every file of its manifest must be
+// listed with the size recorded there. Files listed but missing from the
+// manifest are reported too. Without a manifest, which older uploads lack,
+// the files of the commit object are checked for presence only. objects are
+// the listed backup files of the backupset, without the control objects.
+func VerifyBackupset(bsdir string, objects []ObjectInfo, commit *Commit, manifest *Manifest) []string {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", bsdir)+fmt.Sprintf(format, args...))
+	}
+	if commit == nil {
+		report("no commit object, its upload may not have completed")
+	}
+
+	listed := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		listed[strings.TrimPrefix(filepath.ToSlash(obj.Path), bsdir+"/")] = obj.Size
+	}
+	expected := make(map[string]bool)
+	switch {
+	case manifest != nil:
+		for _, f := range manifest.Files {
+			expected[f.Path] = true
+			size, ok := listed[f.Path]
+			if !ok {
+				report("%s is listed in the manifest but not found", f.Path)
+			} else if size != f.Size {
+				report("%s: size is %d, expected %d", f.Path, size, f.Size)
+			}
+		}
+	case commit != nil:
+		report("no manifest, only checking the files of the commit object are present")
+		for _, f := range commit.Files {
+			expected[f] = true
+			if _, ok := listed[f]; !ok {
+				report("%s is listed in the commit object but not found", f)
+			}
+		}
+	default:
+		return problems
+	}
+	for _, relpath := range sortedKeys(listed) {
+		if !expected[relpath] {
+			report("%s is not part of the uploaded backupset", relpath)
+		}
+	}
+	return problems
+}
+
+// ControlLast orders paths so that the manifest and then the commit object of
+// every backupset come after its other files. Copying in this order leaves a
+// backupset without a commit object until all of its files are copied;
+// deleting in reverse order removes the commit object first.
+func ControlLast(relpaths []string) []string {
+	rank := func(relpath string) int {
+		switch path.Base(filepath.ToSlash(relpath)) {
+		case ManifestName:
+			return 1
+		case CommitName:
+			return 2
+		}
+		return 0
+	}
+	sorted := append([]string{}, relpaths...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
+}
@@ -0,0 +1,168 @@
+package nzbackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommitName is the name of the object uploaded into a backupset directory
+// once every other file of the backupset has been uploaded.
+const CommitName = ".nzconnector.commit"
+
+// Commit is the content of the commit object of a backupset.
+type Commit struct {
+	Version   string    `json:"version"`
+	Completed time.Time `json:"completed"`
+	// Files are the paths of the uploaded files relative to the backupset directory.
+	Files []string `json:"files"`
+}
+
+// BackupsetDir returns the Netezza/<npshost>/<db>/<backupset> directory a
+// path relative to the backup directory belongs to.
+func BackupsetDir(relpath string) (string, bool) {
+	parts := strings.Split(filepath.ToSlash(relpath), "/")
+	if len(parts) < 5 || parts[0] != "Netezza" {
+		return "", false
+	}
+	return path.Join(parts[:4]...), true
+}
+
+// IsCommitPath reports whether relpath names a commit object.
+func IsCommitPath(relpath string) bool {
+	return path.Base(filepath.ToSlash(relpath)) == CommitName
+}
+
+// CommitPath returns the path of the commit object of a backupset directory.
+func CommitPath(bsdir string) string {
+	return path.Join(bsdir, CommitName)
+}
+
+// ParseCommit decodes the content of a commit object.
+func ParseCommit(data []byte) (Commit, error) {
+	var c Commit
+	err := json.Unmarshal(data, &c)
+	if err != nil {
+		return c, fmt.Errorf("Invalid commit object: %v", err)
+	}
+	return c, nil
+}
+
+// Marshal encodes the commit for upload.
+func (c Commit) Marshal() ([]byte, error) {
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// UploadSet collects the files uploaded during a run, grouped by backupset.
+// It is safe for concurrent use.
+type UploadSet struct {
+	mu    sync.Mutex
+	files map[string][]ManifestFile
+}
+
+// Add records a successfully uploaded file given by its path relative to the
+// backup directory and its description from DescribeFile.
+func (u *UploadSet) Add(relpath string, f ManifestFile) {
+	bsdir, ok := BackupsetDir(relpath)
+	if !ok {
+		return
+	}
+	f.Path = strings.TrimPrefix(filepath.ToSlash(relpath), bsdir+"/")
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.files == nil {
+		u.files = make(map[string][]ManifestFile)
+	}
+	u.files[bsdir] = append(u.files[bsdir], f)
+}
+
+// Manifests returns the manifest to upload for every backupset directory seen.
+func (u *UploadSet) Manifests(parameters map[string]string) map[string]Manifest {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	manifests := make(map[string]Manifest, len(u.files))
+	for bsdir, files := range u.files {
+		sorted := append([]ManifestFile(nil), files...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+		manifests[bsdir] = Manifest{Version: Version, Created: time.Now().UTC(), Parameters: parameters, Files: sorted}
+	}
+	return manifests
+}
+
+// NewCommit returns the commit object confirming the upload of a manifest.
+func NewCommit(m Manifest) Commit {
+	files := make([]string, 0, len(m.Files))
+	for _, f := range m.Files {
+		files = append(files, f.Path)
+	}
+	return Commit{Version: Version, Completed: time.Now().UTC(), Files: files}
+}
+
+// CheckComplete groups the listed paths (relative to the unique id) by
+// backupset and describes every backupset that has a manifest but no commit
+// object or whose commit lists files that were not listed. Backupsets with
+// neither a commit object nor a manifest were uploaded before the connectors
+// wrote them; they cannot be checked and are returned as legacy instead.
+// readCommit is only called for commit objects present in relpaths.
+func CheckComplete(relpaths []string, readCommit func(commitpath string) (Commit, error)) (problems []string, legacy []string, err error) {
+	listed := make(map[string]map[string]bool)
+	committed := make(map[string]bool)
+	manifested := make(map[string]bool)
+	for _, relpath := range relpaths {
+		bsdir, ok := BackupsetDir(relpath)
+		if !ok {
+			continue
+		}
+		if listed[bsdir] == nil {
+			listed[bsdir] = make(map[string]bool)
+		}
+		if IsCommitPath(relpath) {
+			committed[bsdir] = true
+		}
+		if IsManifestPath(relpath) {
+			manifested[bsdir] = true
+		}
+		if IsControlPath(relpath) {
+			continue
+		}
+		listed[bsdir][strings.TrimPrefix(filepath.ToSlash(relpath), bsdir+"/")] = true
+	}
+
+	bsdirs := make([]string, 0, len(listed))
+	for bsdir := range listed {
+		bsdirs = append(bsdirs, bsdir)
+	}
+	sort.Strings(bsdirs)
+
+	for _, bsdir := range bsdirs {
+		if !committed[bsdir] && !manifested[bsdir] {
+			legacy = append(legacy, fmt.Sprintf("%s has no commit object or manifest, it was uploaded by an older connector and cannot be checked for completeness", bsdir))
+			continue
+		}
+		if !committed[bsdir] {
+			problems = append(problems, fmt.Sprintf("%s has a manifest but no commit object, its upload may not have completed", bsdir))
+			continue
+		}
+		c, err := readCommit(CommitPath(bsdir))
+		if err != nil {
+			return nil, nil, err
+		}
+		missing := 0
+		for _, f := range c.Files {
+			if !listed[bsdir][f] {
+				missing++
+				problems = append(problems, fmt.Sprintf("%s: %s is listed in the commit object but not found", bsdir, f))
+			}
+		}
+		if missing > 0 {
+			problems = append(problems, fmt.Sprintf("%s is missing %d of %d files", bsdir, missing, len(c.Files)))
+		}
+	}
+	return problems, legacy, nil
+}
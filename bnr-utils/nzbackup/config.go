@@ -0,0 +1,292 @@
+package nzbackup
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigFileName is the configuration file read from the home directory when
+// no -config is given.
+const ConfigFileName = ".nzconnector.conf"
+
+// DefaultProfile is the profile used when no -profile is given.
+const DefaultProfile = "default"
+
+// BackendKey is the profile setting naming the connector a profile is for. It
+// is read by nzcloud and ignored by the connectors.
+const BackendKey = "backend"
+
+// Backends are the names of the connectors, as used by the backend setting
+// and to scope profile keys.
+const (
+	BackendS3    = "s3"
+	BackendAzure = "azure"
+)
+
+// EnvPrefix starts the names of the environment variables setting connector
+// flags. The rest of the name is the flag name in upper case with - replaced
+// by _, so NZ_CONNECTOR_BUCKET_URL sets -bucket-url.
+const EnvPrefix = "NZ_CONNECTOR_"
+
+// Config holds the named profiles of a configuration file. A profile is an
+// INI section whose keys are connector flag names:
+//
+//	[prod-cos]
+//	backend = s3
+//	bucket-url = nzbackups
+//	endpoint = https://s3.us-south.cloud-object-storage.appdomain.cloud
+//	streams = 32
+//
+// A key may be scoped to one connector as <backend>.<flag>, e.g.
+// azure.container = backups, so that a profile can be shared by both.
+type Config struct {
+	Path     string
+	profiles map[string]map[string]string
+}
+
+// DefaultConfigPath returns the path of the configuration file in the home
+// directory, or "" when there is no home directory.
+func DefaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ConfigFileName)
+}
+
+// LoadConfig reads a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, path)
+}
+
+// ParseConfig decodes the content of a configuration file. Lines starting
+// with # or ; are comments, values may be quoted.
+func ParseConfig(data []byte, path string) (*Config, error) {
+	c := &Config{Path: path, profiles: make(map[string]map[string]string)}
+	var section map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("%s:%d: empty profile name", path, n)
+			}
+			if c.profiles[name] == nil {
+				c.profiles[name] = make(map[string]string)
+			}
+			section = c.profiles[name]
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value or [profile]", path, n)
+		}
+		if section == nil {
+			return nil, fmt.Errorf("%s:%d: setting outside of a [profile] section", path, n)
+		}
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		section[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Profiles returns the names of the profiles.
+func (c *Config) Profiles() []string {
+	return sortedKeys(c.profiles)
+}
+
+// Profile returns the settings of a named profile.
+func (c *Config) Profile(name string) (map[string]string, error) {
+	p, ok := c.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("No profile %s in %s, available: %s", name, c.Path, strings.Join(c.Profiles(), ", "))
+	}
+	return p, nil
+}
+
+// LoadProfile returns the settings of the named profile of the configuration
+// file at path, or of the file in the home directory when path is empty. When
+// name is empty the default profile is used if there is one, and no settings
+// otherwise. The name of the profile used is returned.
+func LoadProfile(path, name string) (map[string]string, string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+	if path == "" {
+		if name != "" {
+			return nil, "", fmt.Errorf("Cannot use profile %s: no home directory to read %s from, use -config", name, ConfigFileName)
+		}
+		return nil, "", nil
+	}
+	c, err := LoadConfig(path)
+	if os.IsNotExist(err) && !explicit && name == "" {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("Error reading configuration file: %v", err)
+	}
+	if name == "" {
+		if _, ok := c.profiles[DefaultProfile]; !ok {
+			return nil, "", nil
+		}
+		name = DefaultProfile
+	}
+	p, err := c.Profile(name)
+	return p, name, err
+}
+
+// Settings fills in the flags of a parsed flag set from further sources, such
+// as environment variables and a profile, without overriding flags given on
+// the command line or set from an earlier source, and remembers where every
+// value came from.
+type Settings struct {
+	fs      *flag.FlagSet
+	backend string
+	sources map[string]string
+}
+
+// NewSettings starts from the flags given on the command line of the
+// connector of the given backend.
+func NewSettings(fs *flag.FlagSet, backend string) *Settings {
+	s := &Settings{fs: fs, backend: backend, sources: make(map[string]string)}
+	fs.Visit(func(f *flag.Flag) {
+		s.sources[f.Name] = "command line"
+	})
+	return s
+}
+
+// Apply sets the flags named by values that were not set yet. Keys scoped to
+// the backend of the settings take precedence over unscoped keys, keys scoped
+// to the other backend are ignored. Any other key must be a flag.
+func (s *Settings) Apply(values map[string]string, source string) error {
+	scoped := make(map[string]string)
+	unscoped := make(map[string]string)
+	for key, value := range values {
+		backend, name, ok := strings.Cut(key, ".")
+		switch {
+		case !ok:
+			unscoped[key] = value
+		case backend == s.backend:
+			scoped[name] = value
+		case backend != BackendS3 && backend != BackendAzure:
+			return fmt.Errorf("Unknown setting %s in %s", key, source)
+		}
+	}
+
+	for _, values := range []map[string]string{scoped, unscoped} {
+		for _, key := range sortedKeys(values) {
+			if key == BackendKey {
+				continue
+			}
+			if s.fs.Lookup(key) == nil {
+				return fmt.Errorf("Unknown setting %s in %s", key, source)
+			}
+			if _, ok := s.sources[key]; ok {
+				continue
+			}
+			if err := s.fs.Set(key, values[key]); err != nil {
+				return fmt.Errorf("Invalid setting %s in %s: %v", key, source, err)
+			}
+			s.sources[key] = source
+		}
+	}
+	return nil
+}
+
+// ProfileValue returns the value of a key of a profile for the given backend,
+// preferring the key scoped to the backend.
+func ProfileValue(profile map[string]string, backend string, key string) string {
+	if value, ok := profile[backend+"."+key]; ok {
+		return value
+	}
+	return profile[key]
+}
+
+// EnvName returns the environment variable setting a flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// ApplyEnv sets the flags not set yet from their NZ_CONNECTOR_ variables, and
+// then from the standard variables of the cloud SDKs in standard, which maps
+// flag names to variable names in order of preference. Empty variables are
+// ignored.
+func (s *Settings) ApplyEnv(standard map[string][]string) error {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+	s.fs.VisitAll(func(f *flag.Flag) {
+		names := append([]string{EnvName(f.Name)}, standard[f.Name]...)
+		for _, name := range names {
+			if value := os.Getenv(name); value != "" {
+				values[f.Name] = value
+				sources[f.Name] = "environment " + name
+				break
+			}
+		}
+	})
+	for _, key := range sortedKeys(values) {
+		if err := s.Apply(map[string]string{key: values[key]}, sources[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyProfile applies the profile selected with -config and -profile.
+func (s *Settings) ApplyProfile(path, name string) error {
+	values, name, err := LoadProfile(path, name)
+	if err != nil || values == nil {
+		return err
+	}
+	return s.Apply(values, "profile "+name)
+}
+
+// Report returns the effective value and source of every flag, with the
+// values of the secret flags masked.
+func (s *Settings) Report(secrets ...string) []string {
+	var lines []string
+	s.fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if value != "" && containsString(secrets, f.Name) {
+			value = "****"
+		}
+		source, ok := s.sources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		lines = append(lines, fmt.Sprintf("%-20s = %-40s (%s)", f.Name, value, source))
+	})
+	sort.Strings(lines)
+	return lines
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
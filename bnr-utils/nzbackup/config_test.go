@@ -0,0 +1,40 @@
+package nzbackup
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestSettingsApplyScoped(t *testing.T) {
+	profile := map[string]string{
+		BackendKey:        BackendS3,
+		"streams":         "8",
+		"s3.streams":      "32",
+		"s3.bucket-url":   "nzbackups",
+		"azure.container": "backups",
+		"paralleljobs":    "4",
+	}
+	fs := flag.NewFlagSet("nz_s3Connector", flag.ContinueOnError)
+	streams := fs.Int("streams", 16, "")
+	bucket := fs.String("bucket-url", "", "")
+	parallel := fs.Int("paralleljobs", 6, "")
+	if err := fs.Parse([]string{"-paralleljobs", "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSettings(fs, BackendS3)
+	if err := s.Apply(profile, "profile default"); err != nil {
+		t.Fatal(err)
+	}
+	if *streams != 32 || *bucket != "nzbackups" || *parallel != 2 {
+		t.Errorf("streams = %d, bucket-url = %q, paralleljobs = %d, want 32, nzbackups, 2", *streams, *bucket, *parallel)
+	}
+
+	for _, key := range []string{"container", "s3.container", "gcs.container"} {
+		err := NewSettings(fs, BackendS3).Apply(map[string]string{key: "x"}, "profile default")
+		if err == nil || !strings.Contains(err.Error(), "Unknown setting") {
+			t.Errorf("Apply(%s) = %v, want an unknown setting error", key, err)
+		}
+	}
+}
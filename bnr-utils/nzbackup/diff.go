@@ -0,0 +1,87 @@
+package nzbackup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseBackupsetPair splits the two backupset IDs given as "A,B" or "A B".
+func ParseBackupsetPair(value string) (string, string, error) {
+	ids := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(ids) != 2 {
+		return "", "", fmt.Errorf("Invalid backupsets %q. Give two backupset IDs as A,B", value)
+	}
+	return ids[0], ids[1], nil
+}
+
+// TableChange is a difference of a table between two backupsets.
+type TableChange struct {
+	Name string
+	// Kind is added, dropped, changed or empty.
+	Kind   string
+	Before *TableSummary
+	After  *TableSummary
+}
+
+func (c TableChange) String() string {
+	switch c.Kind {
+	case "added":
+		return fmt.Sprintf("added:   %s objid %s, %s", c.Name, c.After.ObjID, FormatSize(c.After.Size))
+	case "dropped":
+		return fmt.Sprintf("dropped: %s objid %s, %s", c.Name, c.Before.ObjID, FormatSize(c.Before.Size))
+	case "empty":
+		return fmt.Sprintf("no data: %s had %s of data files, none in the second backupset", c.Name, FormatSize(c.Before.Size))
+	}
+	line := fmt.Sprintf("changed: %s %s -> %s (%+d bytes)", c.Name, FormatSize(c.Before.Size), FormatSize(c.After.Size), c.After.Size-c.Before.Size)
+	if c.Before.ObjID != c.After.ObjID {
+		line += fmt.Sprintf(", objid %s -> %s", c.Before.ObjID, c.After.ObjID)
+	}
+	return line
+}
+
+// DiffTables compares the tables of two inspected backupsets by name and
+// returns the tables added, dropped, changed in size or object id, and the
+// tables whose data files are missing from the second backupset. It fails
+// when a schema.xml of either backupset could not be parsed, rather than
+// report its tables as dropped or added.
+func DiffTables(before, after *Inspection) ([]TableChange, error) {
+	for _, in := range []*Inspection{before, after} {
+		if len(in.SchemaErrors) > 0 {
+			return nil, fmt.Errorf("Tables cannot be compared, %s", in.SchemaErrors[0])
+		}
+	}
+	byName := func(in *Inspection) map[string]*TableSummary {
+		tables := make(map[string]*TableSummary, len(in.Tables))
+		for i := range in.Tables {
+			tables[strings.ToUpper(in.Tables[i].String())] = &in.Tables[i]
+		}
+		return tables
+	}
+	a, b := byName(before), byName(after)
+
+	var changes []TableChange
+	for name, t := range a {
+		u, ok := b[name]
+		switch {
+		case !ok:
+			changes = append(changes, TableChange{Name: t.String(), Kind: "dropped", Before: t})
+		case t.Files > 0 && u.Files == 0:
+			changes = append(changes, TableChange{Name: t.String(), Kind: "empty", Before: t, After: u})
+		case t.Size != u.Size || t.ObjID != u.ObjID:
+			changes = append(changes, TableChange{Name: t.String(), Kind: "changed", Before: t, After: u})
+		}
+	}
+	for name, u := range b {
+		if _, ok := a[name]; !ok {
+			changes = append(changes, TableChange{Name: u.String(), Kind: "added", After: u})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package nzbackup
+
+import "fmt"
+
+// MakeFifo is not supported on this platform.
+func MakeFifo(path string) error {
+	return fmt.Errorf("Cannot create named pipe %s: named pipes are not supported on this platform", path)
+}
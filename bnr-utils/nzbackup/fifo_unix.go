@@ -0,0 +1,23 @@
+//go:build unix
+
+package nzbackup
+
+import (
+	"os"
+	"syscall"
+)
+
+// MakeFifo creates a named pipe at path, replacing a regular file left there
+// by an earlier download. An existing named pipe is reused.
+func MakeFifo(path string) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeNamedPipe != 0 {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return syscall.Mkfifo(path, 0666)
+}
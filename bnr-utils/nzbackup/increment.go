@@ -0,0 +1,203 @@
+package nzbackup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Increment is one numbered increment of a backupset, e.g. 2/DIFF.
+type Increment struct {
+	Number int
+	Type   string
+	// Time is the latest modification time of the files of the increment,
+	// as recorded when they were backed up. It is zero when unknown.
+	Time time.Time
+}
+
+func (inc Increment) String() string {
+	return fmt.Sprintf("%d/%s", inc.Number, inc.Type)
+}
+
+// ListIncrements groups the files of a backupset, given by their cloud paths
+// and modification times, into increments sorted by number. A zero time is
+// unknown and leaves the time of its increment unknown.
+func ListIncrements(files map[string]time.Time) ([]Increment, error) {
+	byNumber := make(map[int]*Increment)
+	for relpath, modtime := range files {
+		info := ParsePath(relpath)
+		if info.Increment == "" || info.IncrementType == "" {
+			continue
+		}
+		number, err := strconv.Atoi(info.Increment)
+		if err != nil || number < 1 {
+			return nil, fmt.Errorf("Invalid increment %s in %s", info.Increment, relpath)
+		}
+		incType := strings.ToUpper(info.IncrementType)
+		switch incType {
+		case IncrementFull, IncrementDiff, IncrementCumu:
+		default:
+			return nil, fmt.Errorf("Invalid increment type %s in %s", info.IncrementType, relpath)
+		}
+		inc, ok := byNumber[number]
+		if !ok {
+			inc = &Increment{Number: number, Type: incType, Time: modtime}
+			byNumber[number] = inc
+		} else if inc.Type != incType {
+			return nil, fmt.Errorf("Increment %d is both %s and %s", number, inc.Type, incType)
+		}
+		if !inc.Time.IsZero() && (modtime.IsZero() || modtime.After(inc.Time)) {
+			inc.Time = modtime
+		}
+	}
+
+	incs := make([]Increment, 0, len(byNumber))
+	for _, inc := range byNumber {
+		incs = append(incs, *inc)
+	}
+	sort.Slice(incs, func(i, j int) bool { return incs[i].Number < incs[j].Number })
+	return incs, nil
+}
+
+// IncrementAsOf returns the number of the last increment completed at or
+// before the given time. It fails when the time of an increment is unknown.
+func IncrementAsOf(incs []Increment, asOf time.Time) (int, error) {
+	number := 0
+	for _, inc := range incs {
+		if inc.Time.IsZero() {
+			return 0, fmt.Errorf("Backup time of increment %s is unknown as the backupset has no manifest. Select the increments to download with -increment", inc)
+		}
+		if !inc.Time.After(asOf) {
+			number = inc.Number
+		}
+	}
+	if number == 0 {
+		return 0, fmt.Errorf("No increment of the backupset was completed by %s", asOf.Format(time.RFC3339))
+	}
+	return number, nil
+}
+
+// IncrementChain returns the increments needed to restore up to increment
+// upto: the latest FULL, the latest CUMU after it and every DIFF after that.
+// It fails when one of the increments of the chain is missing.
+func IncrementChain(incs []Increment, upto int) ([]Increment, error) {
+	byNumber := make(map[int]Increment, len(incs))
+	for _, inc := range incs {
+		byNumber[inc.Number] = inc
+	}
+	if _, ok := byNumber[upto]; !ok {
+		return nil, fmt.Errorf("Increment %d not found in the backupset", upto)
+	}
+
+	var chain []Increment
+	cumulative := false
+	for number := upto; number >= 1; number-- {
+		inc, ok := byNumber[number]
+		if !ok {
+			if cumulative {
+				// increments between a CUMU and its FULL are not needed
+				continue
+			}
+			return nil, fmt.Errorf("Increment chain up to %d is broken, increment %d is missing", upto, number)
+		}
+		switch inc.Type {
+		case IncrementDiff:
+			if !cumulative {
+				chain = append(chain, inc)
+			}
+		case IncrementCumu:
+			if !cumulative {
+				chain = append(chain, inc)
+				cumulative = true
+			}
+		case IncrementFull:
+			chain = append(chain, inc)
+			// oldest first
+			for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+				chain[i], chain[j] = chain[j], chain[i]
+			}
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("Increment chain up to %d is broken, no FULL increment found", upto)
+}
+
+// InChain reports whether relpath belongs to one of the increments of chain
+// or to no increment at all.
+func InChain(relpath string, chain []Increment) bool {
+	info := ParsePath(relpath)
+	if info.Increment == "" {
+		return true
+	}
+	for _, inc := range chain {
+		if strconv.Itoa(inc.Number) == info.Increment {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimestamp parses a point in time given as YYYYMMDDhhmmss (the format
+// of backupset IDs), "YYYY-MM-DD hh:mm:ss", YYYY-MM-DD or RFC3339. Times
+// without a zone are taken as local time.
+func ParseTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102150405", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid timestamp %s. Use YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss, YYYY-MM-DD or RFC3339 format", value)
+}
+
+// RestorePoint is the increment a backupset is downloaded up to, given by
+// its number or by a point in time.
+type RestorePoint struct {
+	Increment int
+	AsOf      string
+}
+
+// IsZero reports whether no restore point is set and the whole backupset is downloaded.
+func (rp RestorePoint) IsZero() bool {
+	return rp.Increment == 0 && rp.AsOf == ""
+}
+
+// Check validates the restore point arguments.
+func (rp RestorePoint) Check() error {
+	if rp.Increment < 0 {
+		return fmt.Errorf("Invalid increment %d", rp.Increment)
+	}
+	if rp.Increment != 0 && rp.AsOf != "" {
+		return fmt.Errorf("Only one of -increment and -as-of can be set")
+	}
+	if rp.AsOf != "" {
+		_, err := ParseTimestamp(rp.AsOf)
+		return err
+	}
+	return nil
+}
+
+// Chain returns the increments needed to restore up to the restore point
+// from the files of a backupset, given by their cloud paths and modification times.
+func (rp RestorePoint) Chain(files map[string]time.Time) ([]Increment, error) {
+	incs, err := ListIncrements(files)
+	if err != nil {
+		return nil, err
+	}
+	upto := rp.Increment
+	if rp.AsOf != "" {
+		asOf, err := ParseTimestamp(rp.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		upto, err = IncrementAsOf(incs, asOf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return IncrementChain(incs, upto)
+}
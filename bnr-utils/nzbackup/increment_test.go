@@ -0,0 +1,39 @@
+package nzbackup
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestorePointAsOf(t *testing.T) {
+	const bs = "Netezza/host/db/20240101000000/"
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	files := map[string]time.Time{
+		bs + "1/FULL/md/contents.txt":   day(1),
+		bs + "1/FULL/data/200.full.1.1": day(1),
+		bs + "2/DIFF/md/contents.txt":   day(2),
+		bs + "3/DIFF/md/contents.txt":   day(3),
+	}
+	chain, err := RestorePoint{AsOf: "2024-01-02T18:00:00Z"}.Chain(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].Number != 2 {
+		t.Errorf("Chain() = %v, want [1/FULL 2/DIFF]", chain)
+	}
+
+	// without a manifest the backup times are unknown
+	unknown := make(map[string]time.Time, len(files))
+	for relpath := range files {
+		unknown[relpath] = time.Time{}
+	}
+	_, err = RestorePoint{AsOf: "2024-01-02T18:00:00Z"}.Chain(unknown)
+	if err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("Chain() without backup times = %v, want an error", err)
+	}
+	chain, err = RestorePoint{Increment: 3}.Chain(unknown)
+	if err != nil || len(chain) != 3 {
+		t.Errorf("Chain() by increment = %v, %v, want the whole chain", chain, err)
+	}
+}
@@ -0,0 +1,233 @@
+package nzbackup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectInfo describes a listed cloud object of a backup.
+type ObjectInfo struct {
+	// Path relative to the unique id.
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// IncrementSummary is an increment of an inspected backupset.
+type IncrementSummary struct {
+	Increment
+	Files    int
+	Size     int64
+	Contents int
+}
+
+// TableSummary is a table of an inspected backupset with its data files.
+type TableSummary struct {
+	Table
+	// Increments lists the numbers of the increments holding data of the table.
+	Increments []int
+	Files      int
+	Size       int64
+}
+
+// Inspection describes the content of a backupset from its md files and the
+// object listing, without downloading any data.
+type Inspection struct {
+	Increments []IncrementSummary
+	Tables     []TableSummary
+	// Locations are the directories recorded in locations.txt.
+	Locations []string
+	Files     int
+	Size      int64
+	// Unmatched counts data files whose object id is not in any schema.xml.
+	UnmatchedFiles int
+	UnmatchedSize  int64
+	// SchemaErrors describes the schema.xml files that could not be parsed,
+	// whose tables are missing from Tables.
+	SchemaErrors []string
+	// BackupTimes is set when the increment times are the modification times
+	// recorded in the manifest rather than the upload times of the objects.
+	BackupTimes bool
+}
+
+// Inspect summarizes a backupset from its listed objects. The increment times
+// are taken from manifest when it is not nil. readFile returns the content of
+// an md file given by its path relative to the unique id; only schema.xml,
+// contents.txt and locations.txt files are read.
+func Inspect(objects []ObjectInfo, manifest *Manifest, readFile func(relpath string) ([]byte, error)) (*Inspection, error) {
+	in := &Inspection{BackupTimes: manifest != nil}
+	var backupTimes map[string]time.Time
+	if manifest != nil {
+		backupTimes = make(map[string]time.Time, len(manifest.Files))
+		for _, f := range manifest.Files {
+			backupTimes[f.Path] = f.ModTime
+		}
+	}
+	modtimes := make(map[string]time.Time, len(objects))
+	for _, obj := range objects {
+		modtimes[obj.Path] = obj.ModTime
+		if manifest != nil {
+			// manifest paths are relative to the backupset directory; a file
+			// missing from the manifest leaves its increment time unknown
+			parts := strings.SplitN(filepath.ToSlash(obj.Path), "/", 5)
+			modtimes[obj.Path] = time.Time{}
+			if len(parts) == 5 {
+				modtimes[obj.Path] = backupTimes[parts[4]]
+			}
+		}
+	}
+	incs, err := ListIncrements(modtimes)
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[string]*IncrementSummary, len(incs))
+	in.Increments = make([]IncrementSummary, len(incs))
+	for i, inc := range incs {
+		in.Increments[i].Increment = inc
+		byNumber[strconv.Itoa(inc.Number)] = &in.Increments[i]
+	}
+
+	tables := make(map[string]*TableSummary)
+	// md files are read once even when every location holds a copy
+	seen := make(map[string]bool)
+	for _, obj := range objects {
+		relpath, _ := SplitLocation(obj.Path)
+		info := ParsePath(relpath)
+		if info.Role != "md" || seen[relpath] {
+			continue
+		}
+		name := path.Base(filepath.ToSlash(relpath))
+		if name != SchemaFileName && name != ContentsFileName && name != LocationsFileName {
+			continue
+		}
+		seen[relpath] = true
+		data, err := readFile(obj.Path)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case SchemaFileName:
+			parsed, err := ParseSchema(data, info.Database)
+			if err != nil {
+				in.SchemaErrors = append(in.SchemaErrors, fmt.Sprintf("%s: %v", obj.Path, err))
+				continue
+			}
+			for _, table := range parsed {
+				if _, ok := tables[table.ObjID]; !ok {
+					tables[table.ObjID] = &TableSummary{Table: table}
+				}
+			}
+		case ContentsFileName:
+			if inc, ok := byNumber[info.Increment]; ok {
+				inc.Contents += len(ParseContents(data).lines)
+			}
+		case LocationsFileName:
+			l, err := ParseLocations(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", obj.Path, err)
+			}
+			if len(in.Locations) == 0 {
+				in.Locations = l.Dirs()
+			}
+		}
+	}
+
+	for _, obj := range objects {
+		in.Files++
+		in.Size += obj.Size
+		info := ParsePath(obj.Path)
+		inc, ok := byNumber[info.Increment]
+		if ok {
+			inc.Files++
+			inc.Size += obj.Size
+		}
+		objid, isData := DataObjectID(obj.Path)
+		if !isData {
+			continue
+		}
+		table, known := tables[objid]
+		if !known {
+			in.UnmatchedFiles++
+			in.UnmatchedSize += obj.Size
+			continue
+		}
+		table.Files++
+		table.Size += obj.Size
+		if ok {
+			table.Increments = append(table.Increments, inc.Number)
+		}
+	}
+
+	for _, table := range tables {
+		sort.Ints(table.Increments)
+		table.Increments = uniqueInts(table.Increments)
+		in.Tables = append(in.Tables, *table)
+	}
+	sort.Slice(in.Tables, func(i, j int) bool { return in.Tables[i].String() < in.Tables[j].String() })
+	return in, nil
+}
+
+func uniqueInts(values []int) []int {
+	out := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Report returns the inspection as lines of text.
+func (in *Inspection) Report() []string {
+	lines := []string{fmt.Sprintf("Files: %d, total size: %s", in.Files, FormatSize(in.Size))}
+	if len(in.Locations) > 0 {
+		lines = append(lines, fmt.Sprintf("Locations: %v", in.Locations))
+	}
+	// without a manifest only the upload time of the increments is known
+	event := "uploaded"
+	if in.BackupTimes {
+		event = "completed"
+	}
+	for _, inc := range in.Increments {
+		when := "at an unknown time"
+		if !inc.Time.IsZero() {
+			when = inc.Time.Format(time.RFC3339)
+		}
+		line := fmt.Sprintf("Increment %s %s %s: %d files, %s", inc.Increment, event, when, inc.Files, FormatSize(inc.Size))
+		if inc.Contents > 0 {
+			line += fmt.Sprintf(", %d contents entries", inc.Contents)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fmt.Sprintf("Tables: %d", len(in.Tables)))
+	for _, table := range in.Tables {
+		lines = append(lines, fmt.Sprintf("  %s objid %s: %d data files, %s, increments %v", table.Table, table.ObjID, table.Files, FormatSize(table.Size), table.Increments))
+	}
+	if in.UnmatchedFiles > 0 {
+		lines = append(lines, fmt.Sprintf("Data files of unknown tables: %d, %s", in.UnmatchedFiles, FormatSize(in.UnmatchedSize)))
+	}
+	for _, schemaErr := range in.SchemaErrors {
+		lines = append(lines, fmt.Sprintf("Tables not read: %s", schemaErr))
+	}
+	return lines
+}
+
+// FormatSize returns a byte count in a human readable unit.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,97 @@
+package nzbackup
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func inspectBackupset(t *testing.T, schema string, dataSize int64) *Inspection {
+	t.Helper()
+	const inc = "Netezza/host/db/20240101000000/1/FULL/"
+	modtime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	objects := []ObjectInfo{
+		{Path: inc + "md/" + SchemaFileName, Size: int64(len(schema)), ModTime: modtime},
+		{Path: inc + "data/200.full.1.1", Size: dataSize, ModTime: modtime},
+	}
+	in, err := Inspect(objects, nil, func(relpath string) ([]byte, error) {
+		if strings.HasSuffix(relpath, SchemaFileName) {
+			return []byte(schema), nil
+		}
+		return nil, fmt.Errorf("unexpected read of %s", relpath)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestInspectSchemaError(t *testing.T) {
+	in := inspectBackupset(t, `<DB><RELATION TABLENAME="T1"/></DB>`, 10)
+	if len(in.Tables) != 0 || in.UnmatchedFiles != 1 || len(in.SchemaErrors) != 1 {
+		t.Errorf("Inspect() = %d tables, %d unmatched files, schema errors %q", len(in.Tables), in.UnmatchedFiles, in.SchemaErrors)
+	}
+	if report := strings.Join(in.Report(), "\n"); !strings.Contains(report, "Tables not read") {
+		t.Errorf("Report() does not mention the schema error:\n%s", report)
+	}
+}
+
+func TestInspectTimes(t *testing.T) {
+	const bs = "Netezza/host/db/20240101000000/"
+	uploaded := time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+	objects := []ObjectInfo{
+		{Path: bs + "1/FULL/md/contents.txt", ModTime: uploaded},
+		{Path: bs + "2/DIFF/md/contents.txt", ModTime: uploaded},
+	}
+	manifest := &Manifest{Files: []ManifestFile{
+		{Path: "1/FULL/md/contents.txt", ModTime: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+	}}
+	tests := []struct {
+		name     string
+		manifest *Manifest
+		want     []string
+	}{
+		{"listing", nil, []string{
+			"Increment 1/FULL uploaded 2024-01-03T12:00:00Z",
+			"Increment 2/DIFF uploaded 2024-01-03T12:00:00Z",
+		}},
+		{"manifest", manifest, []string{
+			"Increment 1/FULL completed 2024-01-01T12:00:00Z",
+			"Increment 2/DIFF completed at an unknown time",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := Inspect(objects, tt.manifest, func(relpath string) ([]byte, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := strings.Join(in.Report(), "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(report, want) {
+					t.Errorf("Report() does not contain %q:\n%s", want, report)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffTables(t *testing.T) {
+	before := inspectBackupset(t, validSchema, 10)
+	after := inspectBackupset(t, validSchema, 20)
+	changes, err := DiffTables(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Kind != "changed" {
+		t.Errorf("DiffTables() = %v, want one changed table", changes)
+	}
+
+	unreadable := inspectBackupset(t, `<DB/>`, 20)
+	if _, err := DiffTables(before, unreadable); err == nil {
+		t.Error("DiffTables() compared a backupset whose schema.xml could not be parsed")
+	}
+}
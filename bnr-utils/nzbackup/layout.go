@@ -0,0 +1,79 @@
+// Package nzbackup holds the knowledge about nzbackup output shared by the
+// cloud connectors: directory layout, metadata files and object naming.
+package nzbackup
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the connectors, recorded on every uploaded object.
+// Release builds may override it with -ldflags "-X netezza-utils/bnr-utils/nzbackup.Version=...".
+var Version = "1.0.0"
+
+// Increment types written by nzbackup.
+const (
+	IncrementFull = "FULL"
+	IncrementDiff = "DIFF"
+	IncrementCumu = "CUMU"
+)
+
+// PathInfo describes where a file sits in the nzbackup layout
+// Netezza/<npshost>/<db>/<backupset>/<increment>/<type>/<role>/...
+// Fields are empty when the path does not reach that level.
+type PathInfo struct {
+	NpsHost       string
+	Database      string
+	BackupsetID   string
+	Increment     string
+	IncrementType string
+	Role          string
+	// Location is the number of the backup directory the file belongs to, see LocationPath.
+	Location int
+}
+
+// ParsePath splits a path relative to the backup directory, or a cloud path
+// as returned by LocationPath, into its nzbackup components.
+func ParsePath(relpath string) PathInfo {
+	var info PathInfo
+	relpath, info.Location = SplitLocation(relpath)
+	parts := strings.Split(filepath.ToSlash(relpath), "/")
+	if len(parts) == 0 || parts[0] != "Netezza" {
+		return info
+	}
+	fields := []*string{&info.NpsHost, &info.Database, &info.BackupsetID, &info.Increment, &info.IncrementType, &info.Role}
+	// the last part is the file name, it never fills a layout field
+	for i := 1; i < len(parts)-1 && i <= len(fields); i++ {
+		*fields[i-1] = parts[i]
+	}
+	return info
+}
+
+// ObjectMetadata returns the key/value pairs describing a backup file, used
+// as object metadata and tags. Keys only use lower case letters so that they
+// are valid for both S3 and Azure.
+func ObjectMetadata(relpath string, uploadTime time.Time) map[string]string {
+	info := ParsePath(relpath)
+	md := map[string]string{
+		"connectorversion": Version,
+		"uploadtime":       uploadTime.UTC().Format(time.RFC3339),
+	}
+	if info.BackupsetID != "" {
+		md["location"] = strconv.Itoa(info.Location)
+	}
+	for key, value := range map[string]string{
+		"npshost":       info.NpsHost,
+		"database":      info.Database,
+		"backupset":     info.BackupsetID,
+		"increment":     info.Increment,
+		"incrementtype": info.IncrementType,
+		"filerole":      info.Role,
+	} {
+		if value != "" {
+			md[key] = value
+		}
+	}
+	return md
+}
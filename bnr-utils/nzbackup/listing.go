@@ -0,0 +1,57 @@
+package nzbackup
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// BackupsetLevels returns the number of directory levels between relpath, a
+// Netezza/<npshost>/<db>/<backupset> prefix relative to the unique id that may
+// stop at any level, and the backupset directories under it.
+func BackupsetLevels(relpath string) int {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(relpath), "/"), "/")
+	return max(4-len(parts), 0)
+}
+
+// ListConcurrently calls list for every prefix, up to parallel at once, and
+// returns the results in the order of the prefixes. The error of the first
+// prefix that failed is returned.
+func ListConcurrently[T any](prefixes []string, parallel int, list func(prefix string) ([]T, error)) ([]T, error) {
+	results := make([][]T, len(prefixes))
+	errs := make([]error, len(prefixes))
+	sem := make(chan struct{}, max(parallel, 1))
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = list(prefix)
+			<-sem
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return slices.Concat(results...), nil
+}
+
+// ListDirs returns the directories levels below dir. listDir returns the
+// subdirectories of one directory, as a delimiter listing does, and is run
+// for up to parallel directories of a level at once.
+func ListDirs(dir string, levels int, parallel int, listDir func(dir string) ([]string, error)) ([]string, error) {
+	dirs := []string{dir}
+	for ; levels > 0 && len(dirs) > 0; levels-- {
+		subdirs, err := ListConcurrently(dirs, parallel, listDir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = subdirs
+	}
+	return dirs, nil
+}
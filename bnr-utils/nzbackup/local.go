@@ -0,0 +1,207 @@
+package nzbackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LocalBackups lists the hosts, databases and backupsets found under the
+// Netezza directory of local backup directories.
+type LocalBackups struct {
+	// backupsets by host and database
+	hosts map[string]map[string][]string
+}
+
+// ScanLocal reads <dir>/Netezza/<npshost>/<db>/<backupset> of every directory.
+// Directories without a Netezza directory are ignored.
+func ScanLocal(dirs []string) (*LocalBackups, error) {
+	l := &LocalBackups{hosts: make(map[string]map[string][]string)}
+	for _, dir := range dirs {
+		root := filepath.Join(dir, "Netezza")
+		hosts, err := subdirs(root)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if l.hosts[host] == nil {
+				l.hosts[host] = make(map[string][]string)
+			}
+			dbs, err := subdirs(filepath.Join(root, host))
+			if err != nil {
+				return nil, err
+			}
+			for _, db := range dbs {
+				backupsets, err := subdirs(filepath.Join(root, host, db))
+				if err != nil {
+					return nil, err
+				}
+				l.hosts[host][db] = mergeSorted(l.hosts[host][db], backupsets)
+			}
+		}
+	}
+	return l, nil
+}
+
+func subdirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func mergeSorted(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var out []string
+	for _, v := range append(append([]string{}, a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Hosts returns the npshost names found.
+func (l *LocalBackups) Hosts() []string {
+	return sortedKeys(l.hosts)
+}
+
+// Databases returns the databases found for a host.
+func (l *LocalBackups) Databases(host string) []string {
+	return sortedKeys(l.hosts[host])
+}
+
+// Backupsets returns the backupsets found for a database of a host.
+func (l *LocalBackups) Backupsets(host, db string) []string {
+	return l.hosts[host][db]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Resolve checks the npshost, database and backupset given for an upload
+// against the local backups. Names that do not exist are reported with the
+// close matches and the available names. Empty names keep selecting every
+// host, database or backupset, except that an npshost is selected when a
+// database is given and a database when a backupset is given, provided only
+// one exists.
+func (l *LocalBackups) Resolve(host, db, backupset string) (string, string, string, error) {
+	if host == "" && db == "" && backupset == "" {
+		return "", "", "", nil
+	}
+	host, err := pick("npshost", host, l.Hosts(), "")
+	if err != nil {
+		return "", "", "", err
+	}
+	if db == "" && backupset == "" {
+		return host, "", "", nil
+	}
+	db, err = pick("database", db, l.Databases(host), " for npshost "+host)
+	if err != nil {
+		return "", "", "", err
+	}
+	if backupset != "" {
+		backupset, err = pick("backupset", backupset, l.Backupsets(host, db), " for database "+db)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+	return host, db, backupset, nil
+}
+
+func pick(kind, name string, available []string, scope string) (string, error) {
+	if len(available) == 0 {
+		return "", fmt.Errorf("No %s found%s in the backup directory", kind, scope)
+	}
+	if name == "" {
+		if len(available) > 1 {
+			return "", fmt.Errorf("Missing required field: %s is ambiguous%s, available: %s", kind, scope, strings.Join(available, ", "))
+		}
+		return available[0], nil
+	}
+	for _, candidate := range available {
+		if candidate == name {
+			return name, nil
+		}
+	}
+	msg := fmt.Sprintf("No %s %s found%s, available: %s", kind, name, scope, strings.Join(available, ", "))
+	if matches := closeMatches(name, available); len(matches) > 0 {
+		msg += fmt.Sprintf(". Did you mean %s?", strings.Join(matches, " or "))
+	}
+	return "", fmt.Errorf("%s", msg)
+}
+
+// closeMatches returns the candidates equal to name ignoring case, or else
+// within an edit distance of two.
+func closeMatches(name string, candidates []string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, name) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+	for _, candidate := range candidates {
+		if editDistance(strings.ToLower(candidate), strings.ToLower(name)) <= 2 {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Report returns the local backups as lines of text.
+func (l *LocalBackups) Report() []string {
+	var lines []string
+	for _, host := range l.Hosts() {
+		lines = append(lines, "npshost "+host)
+		for _, db := range l.Databases(host) {
+			lines = append(lines, fmt.Sprintf("  database %s: backupsets %s", db, strings.Join(l.Backupsets(host, db), ", ")))
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No backups found")
+	}
+	return lines
+}
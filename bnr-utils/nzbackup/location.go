@@ -0,0 +1,143 @@
+package nzbackup
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Files of a backup striped across several directories keep their layout
+// relative to each directory. In the cloud the files of location N > 1 are
+// stored below an extra loc<N> directory right after the backupset directory,
+// e.g. Netezza/<npshost>/<db>/<backupset>/loc2/1/FULL/data/..., so that
+// files with the same relative path in different locations do not collide.
+// Location 1 keeps the plain layout.
+var locationDir = regexp.MustCompile(`^loc([0-9]+)$`)
+
+// LocationPath returns the cloud path of a file of the given location from its
+// path relative to the backup directory of that location.
+func LocationPath(relpath string, location int) string {
+	parts := strings.Split(filepath.ToSlash(relpath), "/")
+	if location <= 1 || len(parts) < 5 || parts[0] != "Netezza" {
+		return relpath
+	}
+	located := append(append(append([]string{}, parts[:4]...), "loc"+strconv.Itoa(location)), parts[4:]...)
+	return path.Join(located...)
+}
+
+// SplitLocation is the inverse of LocationPath: it returns the path relative
+// to the backup directory and the location number.
+func SplitLocation(relpath string) (string, int) {
+	parts := strings.Split(filepath.ToSlash(relpath), "/")
+	if len(parts) < 6 || parts[0] != "Netezza" {
+		return relpath, 1
+	}
+	m := locationDir.FindStringSubmatch(parts[4])
+	if m == nil {
+		return relpath, 1
+	}
+	location, err := strconv.Atoi(m[1])
+	if err != nil || location < 1 {
+		return relpath, 1
+	}
+	return path.Join(append(append([]string{}, parts[:4]...), parts[5:]...)...), location
+}
+
+// Placement maps the cloud paths of a backup to local files, restoring every
+// location into its own directory. When fewer directories than locations are
+// given, locations are assigned to directories round robin. The md files and
+// markers nzbackup writes to every location then collide, and the copy of the
+// lowest location is kept; table data files must not collide.
+type Placement struct {
+	dirs      []string
+	locations int
+	rename    Rename
+	targets   map[string]string
+	sources   map[string]string
+	skipped   int
+}
+
+// NewPlacement computes the local path of every cloud path in relpaths, given
+// relative to the unique id. The npshost and database of the local paths are
+// changed as given by rename. It fails when table data files of two locations
+// would be restored to the same local file.
+func NewPlacement(dirs []string, relpaths []string, rename Rename) (*Placement, error) {
+	p := &Placement{
+		dirs:      dirs,
+		locations: 1,
+		rename:    rename,
+		targets:   make(map[string]string, len(relpaths)),
+		sources:   make(map[string]string, len(relpaths)),
+	}
+
+	type located struct {
+		relpath  string
+		orig     string
+		location int
+	}
+	files := make([]located, 0, len(relpaths))
+	for _, relpath := range relpaths {
+		orig, location := SplitLocation(relpath)
+		files = append(files, located{relpath, orig, location})
+		if location > p.locations {
+			p.locations = location
+		}
+	}
+	// the lowest location wins when locations share a directory
+	sort.SliceStable(files, func(i, j int) bool { return files[i].location < files[j].location })
+
+	for _, f := range files {
+		local := filepath.Join(p.LocationDir(f.location), filepath.FromSlash(rename.Path(f.orig)))
+		if first, ok := p.sources[local]; ok {
+			if IsStreamable(f.orig) {
+				return nil, fmt.Errorf("Data files %s and %s would both be restored to %s, give a directory for every location", first, f.relpath, local)
+			}
+			p.skipped++
+			continue
+		}
+		p.targets[f.relpath] = local
+		p.sources[local] = f.relpath
+	}
+	return p, nil
+}
+
+// LocationDir returns the local directory the given location is restored into.
+func (p *Placement) LocationDir(location int) string {
+	return p.dirs[(location-1)%len(p.dirs)]
+}
+
+// LocalPath returns the local file a cloud path relative to the unique id is
+// downloaded to. It returns false for files that are skipped.
+func (p *Placement) LocalPath(relpath string) (string, bool) {
+	local, ok := p.targets[relpath]
+	return local, ok
+}
+
+// Source returns the cloud path, relative to the unique id, a local file is
+// downloaded from.
+func (p *Placement) Source(local string) (string, bool) {
+	relpath, ok := p.sources[local]
+	return relpath, ok
+}
+
+// Locations returns the number of locations found in the backup.
+func (p *Placement) Locations() int {
+	return p.locations
+}
+
+// Skipped returns the number of md files and markers skipped because their location shares a directory with a lower one.
+func (p *Placement) Skipped() int {
+	return p.skipped
+}
+
+// LocationDirs returns the local directories that receive files, in location order.
+func (p *Placement) LocationDirs() []string {
+	if p.locations < len(p.dirs) {
+		return p.dirs[:p.locations]
+	}
+	return p.dirs
+}
@@ -0,0 +1,111 @@
+package nzbackup
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLocationPath(t *testing.T) {
+	const relpath = "Netezza/host/db/20240101000000/1/FULL/data/200.full.1.1"
+	tests := []struct {
+		location int
+		want     string
+	}{
+		{1, relpath},
+		{2, "Netezza/host/db/20240101000000/loc2/1/FULL/data/200.full.1.1"},
+		{12, "Netezza/host/db/20240101000000/loc12/1/FULL/data/200.full.1.1"},
+	}
+	for _, tt := range tests {
+		got := LocationPath(relpath, tt.location)
+		if got != tt.want {
+			t.Errorf("LocationPath(%d) = %s, want %s", tt.location, got, tt.want)
+		}
+		orig, location := SplitLocation(got)
+		if orig != relpath || location != tt.location {
+			t.Errorf("SplitLocation(%s) = %s, %d, want %s, %d", got, orig, location, relpath, tt.location)
+		}
+	}
+}
+
+func TestNewPlacement(t *testing.T) {
+	const bs = "Netezza/host/db/20240101000000/"
+	md := bs + "1/FULL/md/contents.txt"
+	data := func(location int, name string) string {
+		return LocationPath(bs+"1/FULL/data/"+name, location)
+	}
+	relpaths := []string{
+		md, LocationPath(md, 2), LocationPath(md, 3),
+		data(1, "200.full.1.1"), data(2, "200.full.2.1"), data(3, "200.full.3.1"),
+	}
+	tests := []struct {
+		name    string
+		dirs    []string
+		targets map[string]string
+		skipped int
+		used    []string
+	}{
+		{"a directory for every location", []string{"/bk1", "/bk2", "/bk3"},
+			map[string]string{
+				md:                      "/bk1/" + md,
+				LocationPath(md, 2):     "/bk2/" + md,
+				LocationPath(md, 3):     "/bk3/" + md,
+				data(3, "200.full.3.1"): "/bk3/" + bs + "1/FULL/data/200.full.3.1",
+			}, 0, []string{"/bk1", "/bk2", "/bk3"}},
+		{"more locations than directories", []string{"/bk1", "/bk2"},
+			map[string]string{
+				md:                      "/bk1/" + md,
+				LocationPath(md, 2):     "/bk2/" + md,
+				LocationPath(md, 3):     "",
+				data(3, "200.full.3.1"): "/bk1/" + bs + "1/FULL/data/200.full.3.1",
+			}, 1, []string{"/bk1", "/bk2"}},
+		{"fewer locations than directories", []string{"/bk1", "/bk2", "/bk3", "/bk4"},
+			map[string]string{
+				LocationPath(md, 3):     "/bk3/" + md,
+				data(2, "200.full.2.1"): "/bk2/" + bs + "1/FULL/data/200.full.2.1",
+			}, 0, []string{"/bk1", "/bk2", "/bk3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPlacement(tt.dirs, relpaths, Rename{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for relpath, want := range tt.targets {
+				local, ok := p.LocalPath(relpath)
+				if want == "" {
+					if ok {
+						t.Errorf("LocalPath(%s) = %s, want it skipped", relpath, local)
+					}
+					continue
+				}
+				if !ok || local != filepath.FromSlash(want) {
+					t.Errorf("LocalPath(%s) = %s, %v, want %s", relpath, local, ok, want)
+				}
+				if source, _ := p.Source(local); source != relpath {
+					t.Errorf("Source(%s) = %s, want %s", local, source, relpath)
+				}
+			}
+			if p.Locations() != 3 {
+				t.Errorf("Locations() = %d, want 3", p.Locations())
+			}
+			if p.Skipped() != tt.skipped {
+				t.Errorf("Skipped() = %d, want %d", p.Skipped(), tt.skipped)
+			}
+			if got := p.LocationDirs(); !reflect.DeepEqual(got, tt.used) {
+				t.Errorf("LocationDirs() = %v, want %v", got, tt.used)
+			}
+		})
+	}
+
+	// data files of two locations sharing a directory must not overwrite each other
+	colliding := []string{data(1, "200.full.1.1"), data(2, "200.full.1.1")}
+	if _, err := NewPlacement([]string{"/bk1", "/bk2"}, colliding, Rename{}); err != nil {
+		t.Errorf("NewPlacement() with a directory for every location = %v", err)
+	}
+	_, err := NewPlacement([]string{"/bk1"}, colliding, Rename{})
+	if err == nil || !strings.Contains(err.Error(), "200.full.1.1") {
+		t.Errorf("NewPlacement() with colliding data files = %v, want an error", err)
+	}
+}
@@ -0,0 +1,186 @@
+package nzbackup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest object uploaded into a backupset
+// directory right before its commit object.
+const ManifestName = ".nzconnector.manifest.json"
+
+// ManifestFile describes one uploaded file of a backupset.
+type ManifestFile struct {
+	// Path relative to the backupset directory.
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modtime"`
+	// Mode holds the permission bits in octal.
+	Mode   string `json:"mode"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Manifest enumerates every file of a backupset together with the connector
+// parameters used to upload it.
+type Manifest struct {
+	Version    string            `json:"version"`
+	Created    time.Time         `json:"created"`
+	Parameters map[string]string `json:"parameters"`
+	Files      []ManifestFile    `json:"files"`
+}
+
+// IsControlPath reports whether relpath names an object written by the
+// connectors themselves (commit or manifest) rather than a backup file.
+func IsControlPath(relpath string) bool {
+	base := path.Base(filepath.ToSlash(relpath))
+	return base == CommitName || base == ManifestName
+}
+
+// IsManifestPath reports whether relpath names a manifest object.
+func IsManifestPath(relpath string) bool {
+	return path.Base(filepath.ToSlash(relpath)) == ManifestName
+}
+
+// IsBackupsetDir reports whether relpath is exactly a Netezza/<npshost>/<db>/<backupset> directory.
+func IsBackupsetDir(relpath string) bool {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(relpath), "/"), "/")
+	return len(parts) == 4 && parts[0] == "Netezza"
+}
+
+// ManifestPath returns the path of the manifest object of a backupset directory.
+func ManifestPath(bsdir string) string {
+	return path.Join(bsdir, ManifestName)
+}
+
+// DescribeFile returns the manifest entry of a local file, without its path.
+// The SHA-256 checksum is only computed when withChecksum is set.
+func DescribeFile(abspath string, withChecksum bool) (ManifestFile, error) {
+	var f ManifestFile
+	info, err := os.Stat(abspath)
+	if err != nil {
+		return f, err
+	}
+	f.Size = info.Size()
+	f.ModTime = info.ModTime().UTC()
+	f.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	if withChecksum {
+		f.SHA256, err = FileChecksum(abspath)
+	}
+	return f, err
+}
+
+// FileChecksum returns the hex encoded SHA-256 checksum of a local file.
+func FileChecksum(abspath string) (string, error) {
+	fh, err := os.Open(abspath)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseManifest decodes the content of a manifest object.
+func ParseManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return m, fmt.Errorf("Invalid manifest object: %v", err)
+	}
+	return m, nil
+}
+
+// Marshal encodes the manifest for upload.
+func (m Manifest) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Verify compares the downloaded files with the manifest and describes every
+// difference. locate returns the local file of a manifest path, or false when
+// the file was deliberately not downloaded. Checksums are only compared when
+// withChecksum is set and the manifest holds them.
+func (m Manifest) Verify(locate func(path string) (string, bool), withChecksum bool) []string {
+	var problems []string
+	for _, f := range m.Files {
+		abspath, ok := locate(f.Path)
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(abspath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", abspath, err))
+			continue
+		}
+		if info.Size() != f.Size {
+			problems = append(problems, fmt.Sprintf("%s: size is %d, expected %d", abspath, info.Size(), f.Size))
+			continue
+		}
+		if withChecksum && f.SHA256 != "" {
+			sum, err := FileChecksum(abspath)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", abspath, err))
+			} else if sum != f.SHA256 {
+				problems = append(problems, fmt.Sprintf("%s: checksum mismatch", abspath))
+			}
+		}
+	}
+	return problems
+}
+
+// VerifyStreams compares the files streamed into named pipes, given by their
+// manifest path, with the manifest and describes every difference.
+func (m Manifest) VerifyStreams(streamed map[string]ManifestFile, withChecksum bool) []string {
+	var problems []string
+	for _, f := range m.Files {
+		got, ok := streamed[f.Path]
+		if !ok {
+			continue
+		}
+		if got.Size != f.Size {
+			problems = append(problems, fmt.Sprintf("%s: streamed %d bytes, expected %d", f.Path, got.Size, f.Size))
+			continue
+		}
+		if withChecksum && f.SHA256 != "" && got.SHA256 != f.SHA256 {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", f.Path))
+		}
+	}
+	return problems
+}
+
+// ApplyAttributes restores the modification time and permissions recorded in
+// the manifest on the downloaded files, located as for Verify.
+func (m Manifest) ApplyAttributes(locate func(path string) (string, bool)) error {
+	for _, f := range m.Files {
+		abspath, ok := locate(f.Path)
+		if !ok {
+			continue
+		}
+		if f.Mode != "" {
+			mode, err := strconv.ParseUint(f.Mode, 8, 32)
+			if err != nil {
+				return fmt.Errorf("Invalid mode %s of %s in manifest", f.Mode, f.Path)
+			}
+			if err := os.Chmod(abspath, os.FileMode(mode)); err != nil {
+				return err
+			}
+		}
+		if !f.ModTime.IsZero() {
+			if err := os.Chtimes(abspath, f.ModTime, f.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package nzbackup
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the nzbackup metadata files that are rewritten after a restore.
+const (
+	LocationsFileName = "locations.txt"
+	ContentsFileName  = "contents.txt"
+)
+
+// records is a text file of comma separated records, one per line. Fields are
+// kept as written, with their padding and quotes, so that a rewrite only
+// changes the fields that are replaced.
+type records struct {
+	lines           [][]string
+	trailingNewline bool
+}
+
+func parseRecords(data []byte) records {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	r := records{trailingNewline: strings.HasSuffix(text, "\n")}
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return r
+	}
+	for _, line := range strings.Split(text, "\n") {
+		r.lines = append(r.lines, splitFields(line))
+	}
+	return r
+}
+
+// splitFields splits a record at the commas outside of double quotes.
+func splitFields(line string) []string {
+	var fields []string
+	quoted := false
+	start := 0
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			fields = append(fields, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, line[start:])
+}
+
+// fieldValue returns the value of a field without its padding and quotes.
+func fieldValue(field string) string {
+	value := strings.TrimSpace(field)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = strings.ReplaceAll(value[1:len(value)-1], `""`, `"`)
+	}
+	return value
+}
+
+// withValue returns field with its value replaced, keeping its padding and
+// quotes. Values that need quotes are quoted.
+func withValue(field string, value string) string {
+	trimmed := strings.TrimSpace(field)
+	lead := field[:strings.Index(field, trimmed)]
+	trail := field[len(lead)+len(trimmed):]
+	quoted := len(trimmed) >= 2 && trimmed[0] == '"' && trimmed[len(trimmed)-1] == '"'
+	if quoted || strings.ContainsAny(value, "\",") {
+		value = `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+	}
+	return lead + value + trail
+}
+
+func (r records) bytes() []byte {
+	lines := make([]string, 0, len(r.lines))
+	for _, fields := range r.lines {
+		lines = append(lines, strings.Join(fields, ","))
+	}
+	text := strings.Join(lines, "\n")
+	if r.trailingNewline && len(lines) > 0 {
+		text += "\n"
+	}
+	return []byte(text)
+}
+
+// LocationsFile is the content of an nzbackup locations.txt file. Each record
+// describes a backup location, its last field being the directory.
+type LocationsFile struct {
+	records
+}
+
+// ParseLocations decodes a locations.txt file.
+func ParseLocations(data []byte) (*LocationsFile, error) {
+	l := &LocationsFile{parseRecords(data)}
+	for i, fields := range l.lines {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("Invalid record %d in %s: %q", i+1, LocationsFileName, strings.Join(fields, ","))
+		}
+	}
+	return l, nil
+}
+
+// Bytes encodes the file, keeping its trailing newline if it had one.
+func (l *LocationsFile) Bytes() []byte {
+	return l.records.bytes()
+}
+
+// Dirs returns the directory of every record.
+func (l *LocationsFile) Dirs() []string {
+	dirs := make([]string, 0, len(l.lines))
+	for _, fields := range l.lines {
+		dirs = append(dirs, fieldValue(fields[len(fields)-1]))
+	}
+	return dirs
+}
+
+// Relocate adds the directories a backup was restored into, so that nzrestore
+// accepts it from there. Records with the same leading fields describe the
+// same location; the locations are numbered in order of their first record.
+// For every location whose new directory, as returned by dirFor, is not
+// recorded yet, a record with the leading fields of its first record and the
+// new directory is appended. Relocating twice to the same directories changes
+// nothing. Relocate reports whether records were added.
+func (l *LocationsFile) Relocate(dirFor func(location int) string) bool {
+	type location struct {
+		fields []string
+		dirs   map[string]bool
+	}
+	var order []string
+	locations := make(map[string]*location)
+	for _, fields := range l.lines {
+		values := make([]string, 0, len(fields)-1)
+		for _, field := range fields[:len(fields)-1] {
+			values = append(values, fieldValue(field))
+		}
+		key := strings.Join(values, "\x00")
+		loc, ok := locations[key]
+		if !ok {
+			loc = &location{fields: fields[:len(fields)-1], dirs: make(map[string]bool)}
+			locations[key] = loc
+			order = append(order, key)
+		}
+		loc.dirs[fieldValue(fields[len(fields)-1])] = true
+	}
+
+	changed := false
+	for i, key := range order {
+		loc := locations[key]
+		dir := dirFor(i + 1)
+		if loc.dirs[dir] {
+			continue
+		}
+		l.lines = append(l.lines, append(append([]string{}, loc.fields...), withValue("", dir)))
+		changed = true
+	}
+	if changed && len(l.lines) > 0 {
+		// records are line terminated once the file has been rewritten
+		l.trailingNewline = true
+	}
+	return changed
+}
+
+// ContentsFile is the content of an nzbackup contents.txt file. The last field
+// of each record flags whether the entry is available for restore.
+type ContentsFile struct {
+	records
+}
+
+// ParseContents decodes a contents.txt file.
+func ParseContents(data []byte) *ContentsFile {
+	return &ContentsFile{parseRecords(data)}
+}
+
+// Bytes encodes the file, keeping its trailing newline if it had one.
+func (c *ContentsFile) Bytes() []byte {
+	return c.records.bytes()
+}
+
+// MarkRestorable flags every record as available for restore and reports
+// whether any record changed.
+func (c *ContentsFile) MarkRestorable() bool {
+	changed := false
+	for _, fields := range c.lines {
+		if fieldValue(fields[len(fields)-1]) == "0" {
+			fields[len(fields)-1] = withValue(fields[len(fields)-1], "1")
+			changed = true
+		}
+	}
+	return changed
+}
+
+func rewriteFile(path string, update func(data []byte) ([]byte, bool, error)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Unable to open %s to read: %v", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to open %s to read: %v", path, err)
+	}
+	data, changed, err := update(data)
+	if err != nil || !changed {
+		return err
+	}
+	err = os.WriteFile(path, data, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Unable to update %s: %v", path, err)
+	}
+	return nil
+}
+
+// NeedsFixup reports whether the file of a cloud path is one FixupRestore
+// rewrites or checks: locations.txt and contents.txt, and every md file when
+// the npshost or database is renamed.
+func NeedsFixup(relpath string, rename Rename) bool {
+	switch path.Base(filepath.ToSlash(relpath)) {
+	case LocationsFileName, ContentsFileName:
+		return true
+	}
+	return !rename.IsZero() && ParsePath(relpath).Role == "md"
+}
+
+// FixupRestore rewrites the locations.txt and contents.txt files among the
+// downloaded local files so that nzrestore accepts the backup from the
+// directories chosen by the placement. When the placement renames the npshost
+// or database, the database name of contents.txt and the npshost and database
+// names of schema.xml are renamed as well. Other md files are not rewritten;
+// a warning is returned for each of them that mentions an original name, to
+// be checked before the restore.
+func FixupRestore(files []string, p *Placement) (warnings []string, err error) {
+	for _, file := range files {
+		var names renames
+		if source, ok := p.Source(file); ok {
+			names = p.rename.replacements(source)
+		}
+		switch filepath.Base(file) {
+		case LocationsFileName:
+			err = rewriteFile(file, func(data []byte) ([]byte, bool, error) {
+				l, err := ParseLocations(data)
+				if err != nil {
+					return nil, false, fmt.Errorf("%s: %v", file, err)
+				}
+				changed := l.Relocate(p.LocationDir)
+				return l.Bytes(), changed, nil
+			})
+		case ContentsFileName:
+			err = rewriteFile(file, func(data []byte) ([]byte, bool, error) {
+				c := ParseContents(data)
+				renamed := c.renameDatabase(names.database)
+				changed := c.MarkRestorable()
+				return c.Bytes(), renamed || changed, nil
+			})
+		case SchemaFileName:
+			err = rewriteFile(file, func(data []byte) ([]byte, bool, error) {
+				data, changed, err := renameXMLNames(data, names)
+				if err != nil {
+					return nil, false, fmt.Errorf("%s: %v", file, err)
+				}
+				return data, changed, nil
+			})
+		default:
+			if names.empty() {
+				continue
+			}
+			var data []byte
+			data, err = os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to open %s to read: %v", file, err)
+			}
+			if old := referencedName(data, names.all()); old != "" {
+				warnings = append(warnings, fmt.Sprintf("%s mentions %s and was not renamed", file, old))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,169 @@
+package nzbackup
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseLocations(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		dirs  []string
+		bytes string
+	}{
+		{"single location", "1,/nzbackup\n", []string{"/nzbackup"}, "1,/nzbackup\n"},
+		{"multiple locations", "1,/bk1\n2,/bk2\n3,/bk3\n", []string{"/bk1", "/bk2", "/bk3"}, "1,/bk1\n2,/bk2\n3,/bk3\n"},
+		{"no trailing newline", "1,/bk1\n2,/bk2", []string{"/bk1", "/bk2"}, "1,/bk1\n2,/bk2"},
+		{"crlf", "1,/bk1\r\n2,/bk2\r\n", []string{"/bk1", "/bk2"}, "1,/bk1\n2,/bk2\n"},
+		{"empty", "", []string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseLocations([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Dirs(); !slices.Equal(got, tt.dirs) {
+				t.Errorf("Dirs() = %q, want %q", got, tt.dirs)
+			}
+			if got := string(l.Bytes()); got != tt.bytes {
+				t.Errorf("Bytes() = %q, want %q", got, tt.bytes)
+			}
+		})
+	}
+
+	if _, err := ParseLocations([]byte("1,/bk1\n/bk2\n")); err == nil {
+		t.Error("ParseLocations accepted a record without a directory")
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	dirs := map[int]string{1: "/restore1", 2: "/restore2", 3: "/restore3"}
+	dirFor := func(location int) string { return dirs[location] }
+
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		changed bool
+	}{
+		{"single location", "1,/bk1\n", "1,/bk1\n1,/restore1\n", true},
+		{"multiple locations", "1,/bk1\n2,/bk2\n3,/bk3\n",
+			"1,/bk1\n2,/bk2\n3,/bk3\n1,/restore1\n2,/restore2\n3,/restore3\n", true},
+		{"no trailing newline", "1,/bk1\n2,/bk2", "1,/bk1\n2,/bk2\n1,/restore1\n2,/restore2\n", true},
+		{"several records per location", "1,/bk1\n1,/bk1b\n2,/bk2\n", "1,/bk1\n1,/bk1b\n2,/bk2\n1,/restore1\n2,/restore2\n", true},
+		{"one location already restored", "1,/restore1\n2,/bk2\n", "1,/restore1\n2,/bk2\n2,/restore2\n", true},
+		{"restored in place without trailing newline", "1,/restore1\n2,/restore2", "1,/restore1\n2,/restore2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseLocations([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed := l.Relocate(dirFor); changed != tt.changed {
+				t.Errorf("Relocate() = %v, want %v", changed, tt.changed)
+			}
+			if got := string(l.Bytes()); got != tt.want {
+				t.Fatalf("Bytes() = %q, want %q", got, tt.want)
+			}
+
+			// a rerun on its own output changes nothing
+			again, err := ParseLocations(l.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.Relocate(dirFor) {
+				t.Error("second Relocate() reported a change")
+			}
+			if got := string(again.Bytes()); got != tt.want {
+				t.Errorf("second Relocate() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkRestorable(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		changed bool
+	}{
+		{"flags records", "db1,1,0\ndb2,2,1\n", "db1,1,1\ndb2,2,1\n", true},
+		{"no trailing newline", "db1,1,0\ndb2,2,0", "db1,1,1\ndb2,2,1", true},
+		{"already restorable", "db1,1,1\n", "db1,1,1\n", false},
+		{"empty", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ParseContents([]byte(tt.data))
+			if changed := c.MarkRestorable(); changed != tt.changed {
+				t.Errorf("MarkRestorable() = %v, want %v", changed, tt.changed)
+			}
+			if got := string(c.Bytes()); got != tt.want {
+				t.Fatalf("Bytes() = %q, want %q", got, tt.want)
+			}
+
+			again := ParseContents(c.Bytes())
+			if again.MarkRestorable() {
+				t.Error("second MarkRestorable() reported a change")
+			}
+			if got := string(again.Bytes()); got != tt.want {
+				t.Errorf("second MarkRestorable() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixupRestoreRerun(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	relpaths := []string{
+		"Netezza/host/db/20240101000000/1/FULL/md/locations.txt",
+		"Netezza/host/db/20240101000000/1/FULL/md/contents.txt",
+		"Netezza/host/db/20240101000000/loc2/1/FULL/md/locations.txt",
+	}
+	p, err := NewPlacement(dirs, relpaths, Rename{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{
+		relpaths[0]: "1,/bk1\n2,/bk2",
+		relpaths[1]: "db,1,0\n",
+		relpaths[2]: "1,/bk1\n2,/bk2",
+	}
+	var files []string
+	for _, relpath := range relpaths {
+		local, ok := p.LocalPath(relpath)
+		if !ok {
+			t.Fatalf("no local path for %s", relpath)
+		}
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local, []byte(contents[relpath]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, local)
+	}
+
+	wantLocations := "1,/bk1\n2,/bk2\n1," + dirs[0] + "\n2," + dirs[1] + "\n"
+	want := []string{wantLocations, "db,1,1\n", wantLocations}
+	for run := 1; run <= 2; run++ {
+		if _, err := FixupRestore(files, p); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		for i, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != want[i] {
+				t.Errorf("run %d: %s = %q, want %q", run, relpaths[i], data, want[i])
+			}
+		}
+	}
+}
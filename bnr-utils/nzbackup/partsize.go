@@ -0,0 +1,94 @@
+package nzbackup
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+const mib = 1024 * 1024
+
+// PartLimits are the limits a cloud provider puts on objects uploaded in
+// parts (S3 multipart uploads, Azure block blobs).
+type PartLimits struct {
+	MaxParts  int64
+	MinPart   int64
+	MaxPart   int64
+	MaxObject int64
+}
+
+// S3Limits are the multipart upload limits of AWS S3, which IBM Cloud Object
+// Storage shares.
+var S3Limits = PartLimits{
+	MaxParts:  10000,
+	MinPart:   5 * mib,
+	MaxPart:   5 * 1024 * mib,
+	MaxObject: 5 * 1024 * 1024 * mib,
+}
+
+// AzureLimits are the block blob limits of Azure blob storage.
+var AzureLimits = PartLimits{
+	MaxParts:  50000,
+	MinPart:   1 * mib,
+	MaxPart:   4000 * mib,
+	MaxObject: 50000 * 4000 * mib,
+}
+
+// PartSize returns the part size in bytes to upload a file of size bytes: the
+// preferred size, raised as far as needed to stay within the part count
+// limit, and bounded by minSize and maxSize (0 for no bound of their own) and
+// the provider limits. A size below 0 stands for a stream of unknown length,
+// which gets the bounded preferred size. Files no larger than the part size
+// are uploaded with a single request. All sizes are in bytes and the result is
+// a whole number of MiB.
+func (l PartLimits) PartSize(size, preferred, minSize, maxSize int64) (int64, error) {
+	lo, hi := l.MinPart, l.MaxPart
+	if minSize > 0 {
+		lo = max(lo, minSize)
+	}
+	if maxSize > 0 {
+		hi = min(hi, maxSize)
+	}
+	if lo > hi {
+		return 0, fmt.Errorf("Minimum block size %s is larger than the maximum block size %s", FormatSize(lo), FormatSize(hi))
+	}
+	part := min(max(preferred, lo), hi)
+	if size > l.MaxObject {
+		return 0, fmt.Errorf("File of %s is larger than the maximum object size %s", FormatSize(size), FormatSize(l.MaxObject))
+	}
+	if size > part*l.MaxParts {
+		part = (size + l.MaxParts - 1) / l.MaxParts
+		if part > hi {
+			return 0, fmt.Errorf("File of %s needs blocks of at least %s to stay within %d blocks, above the maximum block size %s",
+				FormatSize(size), FormatSize(part), l.MaxParts, FormatSize(hi))
+		}
+	}
+	return (part + mib - 1) / mib * mib, nil
+}
+
+// CheckPartSizes fails before anything is uploaded if a file in dirs cannot
+// be uploaded within the limits.
+func (l PartLimits) CheckPartSizes(dirs []string, preferred, minSize, maxSize int64) error {
+	if _, err := l.PartSize(-1, preferred, minSize, maxSize); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if _, err := l.PartSize(info.Size(), preferred, minSize, maxSize); err != nil {
+				return fmt.Errorf("Cannot upload %s: %v", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
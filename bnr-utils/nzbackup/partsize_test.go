@@ -0,0 +1,104 @@
+package nzbackup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPartSize(t *testing.T) {
+	const gib = 1024 * mib
+	tests := []struct {
+		name      string
+		limits    PartLimits
+		size      int64
+		preferred int64
+		minSize   int64
+		maxSize   int64
+		want      int64
+		err       string
+	}{
+		{"s3 small file", S3Limits, 10, 100 * mib, 0, 0, 100 * mib, ""},
+		{"s3 stream", S3Limits, -1, 100 * mib, 0, 0, 100 * mib, ""},
+		{"s3 preferred below the minimum part", S3Limits, 10, mib, 0, 0, 5 * mib, ""},
+		{"s3 preferred above the maximum part", S3Limits, 10, 6 * gib, 0, 0, 5 * gib, ""},
+		{"s3 preferred rounded up to MiB", S3Limits, 10, 100*mib + 1, 0, 0, 101 * mib, ""},
+		{"s3 at the part count", S3Limits, 10000 * 100 * mib, 100 * mib, 0, 0, 100 * mib, ""},
+		{"s3 one byte over the part count", S3Limits, 10000*100*mib + 1, 100 * mib, 0, 0, 101 * mib, ""},
+		{"s3 at the maximum object", S3Limits, S3Limits.MaxObject, 100 * mib, 0, 0, 525 * mib, ""},
+		{"s3 over the maximum object", S3Limits, S3Limits.MaxObject + 1, 100 * mib, 0, 0, 0, "maximum object size"},
+		{"s3 raised to the minimum size", S3Limits, 10, 100 * mib, 200 * mib, 0, 200 * mib, ""},
+		{"s3 lowered to the maximum size", S3Limits, 10, 100 * mib, 0, 50 * mib, 50 * mib, ""},
+		{"s3 minimum size below the limit", S3Limits, 10, mib, 2 * mib, 0, 5 * mib, ""},
+		{"s3 maximum size above the limit", S3Limits, 10, 6 * gib, 0, 6 * gib, 5 * gib, ""},
+		{"s3 minimum above maximum", S3Limits, 10, 100 * mib, 200 * mib, 100 * mib, 0, "larger than the maximum block size"},
+		{"s3 too large for the maximum size", S3Limits, 10000*50*mib + 1, 50 * mib, 0, 50 * mib, 0, "above the maximum block size"},
+		{"azure small file", AzureLimits, 10, 100 * mib, 0, 0, 100 * mib, ""},
+		{"azure preferred below the minimum part", AzureLimits, 10, mib / 2, 0, 0, mib, ""},
+		{"azure preferred above the maximum part", AzureLimits, 10, 5 * gib, 0, 0, 4000 * mib, ""},
+		{"azure at the part count", AzureLimits, 50000 * 100 * mib, 100 * mib, 0, 0, 100 * mib, ""},
+		{"azure one byte over the part count", AzureLimits, 50000*100*mib + 1, 100 * mib, 0, 0, 101 * mib, ""},
+		{"azure at the maximum object", AzureLimits, AzureLimits.MaxObject, 100 * mib, 0, 0, 4000 * mib, ""},
+		{"azure over the maximum object", AzureLimits, AzureLimits.MaxObject + 1, 100 * mib, 0, 0, 0, "maximum object size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.limits.PartSize(tt.size, tt.preferred, tt.minSize, tt.maxSize)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("PartSize() = %d, %v, want an error containing %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("PartSize() = %s, want %s", FormatSize(got), FormatSize(tt.want))
+			}
+		})
+	}
+}
+
+func TestCheckPartSizes(t *testing.T) {
+	// small limits keep the test files small: at most 2 parts of 1 to 2 MiB
+	limits := PartLimits{MaxParts: 2, MinPart: mib, MaxPart: 2 * mib, MaxObject: 10 * mib}
+	tests := []struct {
+		name    string
+		size    int64
+		minSize int64
+		maxSize int64
+		err     string
+	}{
+		{"fits", 4 * mib, 0, 0, ""},
+		{"too large for the limits", 4*mib + 1, 0, 0, "Cannot upload"},
+		{"too large for the maximum size", 2*mib + 1, 0, mib, "Cannot upload"},
+		{"minimum above maximum", 10, 2 * mib, mib, "larger than the maximum block size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "1", "FULL", "data", "200.full.1.1")
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(file, tt.size); err != nil {
+				t.Fatal(err)
+			}
+			err := limits.CheckPartSizes([]string{dir}, mib, tt.minSize, tt.maxSize)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("CheckPartSizes() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("CheckPartSizes() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
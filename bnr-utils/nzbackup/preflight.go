@@ -0,0 +1,104 @@
+package nzbackup
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProbeDir is the directory of the probe objects written by -check, outside
+// of the Netezza tree so that listings of backups never see them.
+const ProbeDir = ".nzcheck"
+
+// ProbePath returns a path for a -check probe object, relative to the unique
+// id, that does not collide with concurrent checks.
+func ProbePath() string {
+	return ProbeDir + "/" + scratchName()
+}
+
+// scratchName names the objects of one run after the host and process.
+func scratchName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+// ProbeData is the content written to and read back from the probe objects.
+func ProbeData(probe string) []byte {
+	return []byte("nzcheck probe " + probe + "\n")
+}
+
+// PreflightStep is the outcome of one step of a -check.
+type PreflightStep struct {
+	Name string
+	// Err is set when the step failed
+	Err error
+	// Skipped names the step this one depends on that did not pass
+	Skipped string
+}
+
+func (s PreflightStep) String() string {
+	switch {
+	case s.Skipped != "":
+		return fmt.Sprintf("SKIP  %s: not run, %s did not pass", s.Name, s.Skipped)
+	case s.Err != nil:
+		return fmt.Sprintf("FAIL  %s: %v", s.Name, s.Err)
+	default:
+		return fmt.Sprintf("OK    %s", s.Name)
+	}
+}
+
+// Preflight runs the steps of a -check in order, skipping the steps that
+// depend on a step that did not succeed.
+type Preflight struct {
+	Steps []PreflightStep
+}
+
+// Run runs a step unless one of the steps it requires did not succeed, and
+// returns the recorded outcome.
+func (p *Preflight) Run(name string, requires []string, fn func() error) PreflightStep {
+	step := PreflightStep{Name: name}
+	for _, r := range requires {
+		if prev, ok := p.find(r); !ok || prev.Err != nil || prev.Skipped != "" {
+			step.Skipped = r
+			break
+		}
+	}
+	if step.Skipped == "" {
+		step.Err = fn()
+	}
+	p.Steps = append(p.Steps, step)
+	return step
+}
+
+func (p *Preflight) find(name string) (PreflightStep, bool) {
+	for _, s := range p.Steps {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return PreflightStep{}, false
+}
+
+// Failed returns the names of the steps that failed.
+func (p *Preflight) Failed() []string {
+	var failed []string
+	for _, s := range p.Steps {
+		if s.Err != nil {
+			failed = append(failed, s.Name)
+		}
+	}
+	return failed
+}
+
+// Err returns an error naming the failed steps, or nil when all succeeded.
+func (p *Preflight) Err() error {
+	failed := p.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Pre-flight check failed: %s", strings.Join(failed, ", "))
+}
@@ -0,0 +1,197 @@
+package nzbackup
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rename maps the npshost and database of a downloaded backup to new names,
+// e.g. to restore a production backup on a test system. Empty fields keep
+// the original name.
+type Rename struct {
+	NpsHost  string
+	Database string
+}
+
+// IsZero reports whether the rename keeps all original names.
+func (r Rename) IsZero() bool {
+	return r.NpsHost == "" && r.Database == ""
+}
+
+// Path returns relpath with the npshost and database components of its
+// Netezza/<npshost>/<db> prefix replaced.
+func (r Rename) Path(relpath string) string {
+	parts := strings.Split(filepath.ToSlash(relpath), "/")
+	if r.IsZero() || len(parts) < 3 || parts[0] != "Netezza" {
+		return relpath
+	}
+	if r.NpsHost != "" {
+		parts[1] = r.NpsHost
+	}
+	if r.Database != "" {
+		parts[2] = r.Database
+	}
+	return path.Join(parts...)
+}
+
+// renames holds the original and new npshost and database names a rename
+// changes in the md files of a backupset, keyed by original name. A kept name
+// has no entry.
+type renames struct {
+	host     map[string]string
+	database map[string]string
+}
+
+func (n renames) empty() bool {
+	return len(n.host) == 0 && len(n.database) == 0
+}
+
+// all returns the host and database renames together.
+func (n renames) all() map[string]string {
+	names := make(map[string]string, len(n.host)+len(n.database))
+	for old, name := range n.host {
+		names[old] = name
+	}
+	for old, name := range n.database {
+		names[old] = name
+	}
+	return names
+}
+
+// replacements returns the names the rename changes in a file of the given path.
+func (r Rename) replacements(relpath string) renames {
+	info := ParsePath(relpath)
+	n := renames{host: make(map[string]string), database: make(map[string]string)}
+	if r.NpsHost != "" && info.NpsHost != "" && info.NpsHost != r.NpsHost {
+		n.host[info.NpsHost] = r.NpsHost
+	}
+	if r.Database != "" && info.Database != "" && info.Database != r.Database {
+		n.database[info.Database] = r.Database
+	}
+	return n
+}
+
+// renameDatabase replaces the database name in the first field of every
+// record of contents.txt. Other fields, which may hold table or schema names
+// equal to the database name, are kept. It reports whether a field changed.
+func (c *ContentsFile) renameDatabase(names map[string]string) bool {
+	changed := false
+	for _, fields := range c.lines {
+		if name, ok := names[fieldValue(fields[0])]; ok {
+			fields[0] = withValue(fields[0], name)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// xmlHostFields are the schema.xml attributes and elements holding the npshost.
+var xmlHostFields = []string{"HOST", "NPSHOST"}
+
+// renameXMLNames replaces the npshost and database names of a schema.xml:
+// the NAME of DATABASE elements, DATABASE attributes and elements, and HOST
+// or NPSHOST attributes and elements, whose value, ignoring surrounding
+// whitespace, is an old name. Names of tables, schemas, columns and owners
+// are kept even when equal to an old name, and everything else is kept byte
+// for byte. It reports whether a value changed.
+func renameXMLNames(data []byte, n renames) ([]byte, bool, error) {
+	// names returns the renames of the value of field of an element
+	names := func(element, field string) map[string]string {
+		switch {
+		case element == "DATABASE" && field == "NAME", field == "DATABASE":
+			return n.database
+		case slices.Contains(xmlHostFields, field):
+			return n.host
+		}
+		return nil
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// names are plain identifiers, whatever the declared encoding
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	var out bytes.Buffer
+	changed := false
+	var offset int64
+	var stack []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("Invalid XML: %v", err)
+		}
+		raw := data[offset:decoder.InputOffset()]
+		offset = decoder.InputOffset()
+		switch t := token.(type) {
+		case xml.StartElement:
+			element := strings.ToUpper(t.Name.Local)
+			for _, attr := range t.Attr {
+				value := strings.TrimSpace(attr.Value)
+				if name, ok := names(element, strings.ToUpper(attr.Name.Local))[value]; ok {
+					raw = replaceAttrValue(raw, attr.Name.Local, value, name)
+					changed = true
+				}
+			}
+			stack = append(stack, element)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 {
+				break
+			}
+			parent := ""
+			if len(stack) > 1 {
+				parent = stack[len(stack)-2]
+			}
+			value := strings.TrimSpace(string(t))
+			if name, ok := names(parent, stack[len(stack)-1])[value]; ok {
+				if i := bytes.Index(raw, []byte(value)); i >= 0 {
+					raw = slices.Concat(raw[:i], escapeXML(name), raw[i+len(value):])
+					changed = true
+				}
+			}
+		}
+		out.Write(raw)
+	}
+	out.Write(data[offset:])
+	return out.Bytes(), changed, nil
+}
+
+// replaceAttrValue replaces the value of the attribute attr of a start tag,
+// in either quotes and with optional padding, with name when it is old.
+func replaceAttrValue(tag []byte, attr string, old string, name string) []byte {
+	for _, quote := range []string{`"`, `'`} {
+		re := regexp.MustCompile(`(\s(?:[\w.-]+:)?` + regexp.QuoteMeta(attr) + `\s*=\s*` + quote + `\s*)` + regexp.QuoteMeta(old) + `(\s*` + quote + `)`)
+		tag = re.ReplaceAllFunc(tag, func(m []byte) []byte {
+			sub := re.FindSubmatch(m)
+			return slices.Concat(sub[1], escapeXML(name), sub[2])
+		})
+	}
+	return tag
+}
+
+func escapeXML(s string) []byte {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.Bytes()
+}
+
+// referencedName returns an old name that data refers to as a whole word, or
+// "" when it refers to none.
+func referencedName(data []byte, names map[string]string) string {
+	for old := range names {
+		re := regexp.MustCompile(`(^|[^A-Za-z0-9_$])` + regexp.QuoteMeta(old) + `($|[^A-Za-z0-9_$])`)
+		if re.Match(data) {
+			return old
+		}
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package nzbackup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenameDatabase(t *testing.T) {
+	names := map[string]string{"PROD": "TEST"}
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		changed bool
+	}{
+		{"plain", "PROD,1,1\n", "TEST,1,1\n", true},
+		{"padded", " PROD ,1,1\n", " TEST ,1,1\n", true},
+		{"quoted", `"PROD",1,1` + "\n", `"TEST",1,1` + "\n", true},
+		{"quoted and padded", ` "PROD" ,1,1`, ` "TEST" ,1,1`, true},
+		{"table named like the database", "PROD,PROD,\"PROD\",1\n", "TEST,PROD,\"PROD\",1\n", true},
+		{"part of a value", "PRODUCTION,1,1\n", "PRODUCTION,1,1\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ParseContents([]byte(tt.data))
+			if changed := c.renameDatabase(names); changed != tt.changed {
+				t.Errorf("renameDatabase() = %v, want %v", changed, tt.changed)
+			}
+			if got := string(c.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameXMLNames(t *testing.T) {
+	names := renames{host: map[string]string{"nps1": "nps2"}, database: map[string]string{"PROD": "TEST"}}
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		changed bool
+	}{
+		{"attributes",
+			`<DATABASE NAME="PROD" HOST='nps1' OWNER="ADMIN"><TABLE NAME="T1" OBJID="200" DATABASE = " PROD "/></DATABASE>`,
+			`<DATABASE NAME="TEST" HOST='nps2' OWNER="ADMIN"><TABLE NAME="T1" OBJID="200" DATABASE = " TEST "/></DATABASE>`, true},
+		{"element text",
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<DATABASE>\n  <NAME> PROD </NAME>\n  <NPSHOST>nps1</NPSHOST>\n</DATABASE>\n",
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<DATABASE>\n  <NAME> TEST </NAME>\n  <NPSHOST>nps2</NPSHOST>\n</DATABASE>\n", true},
+		{"cdata", `<DATABASE><NAME><![CDATA[PROD]]></NAME></DATABASE>`, `<DATABASE><NAME><![CDATA[TEST]]></NAME></DATABASE>`, true},
+		{"table, schema, column and owner named like the database",
+			`<DATABASE NAME="PROD"><SCHEMA NAME="PROD"><TABLE NAME="PROD" OBJID="200" OWNER="PROD"><NAME>PROD</NAME><COLUMN NAME="PROD"/></TABLE></SCHEMA></DATABASE>`,
+			`<DATABASE NAME="TEST"><SCHEMA NAME="PROD"><TABLE NAME="PROD" OBJID="200" OWNER="PROD"><NAME>PROD</NAME><COLUMN NAME="PROD"/></TABLE></SCHEMA></DATABASE>`, true},
+		{"part of a value",
+			`<DATABASE NAME="PRODUCTION"><!-- PROD --><DEF>CREATE TABLE PROD.T1</DEF></DATABASE>`,
+			`<DATABASE NAME="PRODUCTION"><!-- PROD --><DEF>CREATE TABLE PROD.T1</DEF></DATABASE>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed, err := renameXMLNames([]byte(tt.data), names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tt.changed {
+				t.Errorf("renameXMLNames() changed = %v, want %v", changed, tt.changed)
+			}
+			if string(got) != tt.want {
+				t.Errorf("renameXMLNames() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := renameXMLNames([]byte(`<DATABASE NAME="PROD">`), names); err == nil {
+		t.Error("renameXMLNames accepted truncated XML")
+	}
+}
+
+func TestFixupRestoreRename(t *testing.T) {
+	const md = "Netezza/nps1/PROD/20240101000000/1/FULL/md/"
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    map[string]string
+		warning string
+	}{
+		{"renamed",
+			map[string]string{
+				"locations.txt": "1,/bk1\n",
+				"contents.txt":  "\"PROD\",PROD,0\n",
+				"schema.xml":    `<DATABASE NAME="PROD"><TABLE NAME="PROD" OBJID="200"/></DATABASE>`,
+				"stream.0.1":    "no original name in here",
+			},
+			map[string]string{
+				"contents.txt": "\"TEST\",PROD,1\n",
+				"schema.xml":   `<DATABASE NAME="TEST"><TABLE NAME="PROD" OBJID="200"/></DATABASE>`,
+				"stream.0.1":   "no original name in here",
+			}, ""},
+		{"unknown md file mentions the database",
+			map[string]string{
+				"locations.txt": "1,/bk1\n",
+				"stream.0.1":    "CONNECT PROD;\n",
+			},
+			map[string]string{
+				"stream.0.1": "CONNECT PROD;\n",
+			}, "mentions PROD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var relpaths []string
+			for name := range tt.files {
+				relpaths = append(relpaths, md+name)
+			}
+			rename := Rename{NpsHost: "nps2", Database: "TEST"}
+			p, err := NewPlacement([]string{dir}, relpaths, rename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, relpath := range relpaths {
+				if !NeedsFixup(relpath, rename) {
+					t.Errorf("NeedsFixup(%s) = false", relpath)
+				}
+				local, _ := p.LocalPath(relpath)
+				if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(local, []byte(tt.files[filepath.Base(relpath)]), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, local)
+			}
+
+			warnings, err := FixupRestore(files, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if tt.warning != "" {
+				want = 1
+			}
+			if len(warnings) != want || want == 1 && !strings.Contains(warnings[0], tt.warning) {
+				t.Errorf("FixupRestore() warnings = %q, want %q", warnings, tt.warning)
+			}
+			for name, want := range tt.want {
+				data, err := os.ReadFile(filepath.Join(dir, "Netezza/nps2/TEST/20240101000000/1/FULL/md", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+
+	if NeedsFixup(md+"schema.xml", Rename{}) {
+		t.Error("NeedsFixup(schema.xml) = true without a rename")
+	}
+}
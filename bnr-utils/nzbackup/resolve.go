@@ -0,0 +1,70 @@
+package nzbackup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BackupsetLatest is the -backupset value selecting the newest backupset.
+const BackupsetLatest = "latest"
+
+// backupsetLayout is the timestamp format of backupset IDs.
+const backupsetLayout = "20060102150405"
+
+// BackupsetTime returns the time a backupset was started, encoded in its ID.
+func BackupsetTime(id string) (time.Time, bool) {
+	t, err := time.ParseInLocation(backupsetLayout, id, time.Local)
+	return t, err == nil
+}
+
+// ResolveBackupset returns the ID of the newest backupset among the listed
+// paths, relative to the unique id, that was started at or before the given
+// time, or at any time when before is zero. Unless allowIncomplete is set,
+// backupsets with a manifest but no commit object are skipped. Backupsets with
+// neither were uploaded by older connectors and are considered, see
+// CheckComplete.
+func ResolveBackupset(relpaths []string, before time.Time, allowIncomplete bool) (string, error) {
+	committed := make(map[string]bool)
+	manifested := make(map[string]bool)
+	for _, relpath := range relpaths {
+		info := ParsePath(relpath)
+		if info.BackupsetID == "" {
+			continue
+		}
+		if _, ok := committed[info.BackupsetID]; !ok {
+			committed[info.BackupsetID] = false
+		}
+		if IsCommitPath(relpath) {
+			committed[info.BackupsetID] = true
+		}
+		if IsManifestPath(relpath) {
+			manifested[info.BackupsetID] = true
+		}
+	}
+
+	var ids []string
+	skipped := 0
+	for id, done := range committed {
+		started, ok := BackupsetTime(id)
+		if !ok || (!before.IsZero() && started.After(before)) {
+			continue
+		}
+		if !done && manifested[id] && !allowIncomplete {
+			skipped++
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		if skipped > 0 {
+			return "", fmt.Errorf("No complete backupset found, %d backupsets have no commit object. Use -allow-incomplete to select them", skipped)
+		}
+		if !before.IsZero() {
+			return "", fmt.Errorf("No backupset found started at or before %s", before.Format(time.RFC3339))
+		}
+		return "", fmt.Errorf("No backupset found")
+	}
+	sort.Strings(ids)
+	return ids[len(ids)-1], nil
+}
@@ -0,0 +1,152 @@
+package nzbackup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IsStream reports whether a file of the backup directory is a named pipe or
+// character device, whose length is unknown until nzbackup closes it.
+func IsStream(info os.FileInfo) bool {
+	return info.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) != 0
+}
+
+// StreamReader reads a stream for upload and describes what it read, so that
+// its manifest entry can be written once the upload is complete. It does not
+// expose Seek, which makes the uploaders read it in parts of unknown number.
+type StreamReader struct {
+	r    io.Reader
+	mode os.FileMode
+	size int64
+	hash hash.Hash
+}
+
+// NewStreamReader wraps a stream. The SHA-256 checksum is only computed when
+// withChecksum is set.
+func NewStreamReader(r io.Reader, mode os.FileMode, withChecksum bool) *StreamReader {
+	s := &StreamReader{r: r, mode: mode}
+	if withChecksum {
+		s.hash = sha256.New()
+	}
+	return s
+}
+
+func (s *StreamReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.size += int64(n)
+	if s.hash != nil {
+		s.hash.Write(p[:n])
+	}
+	return n, err
+}
+
+// File returns the manifest entry of the data read so far, without its path.
+// The modification time is the time the stream ended.
+func (s *StreamReader) File() ManifestFile {
+	f := ManifestFile{
+		Size:    s.size,
+		ModTime: time.Now().UTC(),
+		Mode:    fmt.Sprintf("%04o", s.mode.Perm()),
+	}
+	if s.hash != nil {
+		f.SHA256 = hex.EncodeToString(s.hash.Sum(nil))
+	}
+	return f
+}
+
+// CheckStreamPath checks the path a stream is uploaded as, relative to the
+// backupset directory, such as 1/FULL/data/200345.full.1.1.
+func CheckStreamPath(relpath string) error {
+	clean := path.Clean(filepath.ToSlash(relpath))
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("Invalid stream path %q, it must be relative to the backupset directory", relpath)
+	}
+	if IsControlPath(clean) {
+		return fmt.Errorf("Invalid stream path %q, the name is reserved for the connector", relpath)
+	}
+	return nil
+}
+
+// IsStreamable reports whether a downloaded file can be streamed into a named
+// pipe for nzrestore: the table data files, which nzrestore reads once from
+// start to end. Metadata and marker files are always written to disk.
+func IsStreamable(relpath string) bool {
+	_, ok := DataObjectID(relpath)
+	return ok
+}
+
+// RangeReader reads an object of known size from start to end by fetching it
+// in chunks, keeping up to a given number of chunks in flight ahead of the
+// consumer.
+type RangeReader struct {
+	chunks chan chan rangeChunk
+	stop   chan struct{}
+	buf    []byte
+	err    error
+}
+
+type rangeChunk struct {
+	data []byte
+	err  error
+}
+
+// NewRangeReader starts reading size bytes in chunks of chunkSize with fetch,
+// at most ahead chunks ahead of the consumer.
+func NewRangeReader(size, chunkSize int64, ahead int, fetch func(offset, count int64) ([]byte, error)) *RangeReader {
+	if ahead < 1 {
+		ahead = 1
+	}
+	r := &RangeReader{chunks: make(chan chan rangeChunk, ahead), stop: make(chan struct{})}
+	go func() {
+		defer close(r.chunks)
+		for offset := int64(0); offset < size; offset += chunkSize {
+			count := min(chunkSize, size-offset)
+			c := make(chan rangeChunk, 1)
+			select {
+			case r.chunks <- c:
+			case <-r.stop:
+				return
+			}
+			go func() {
+				data, err := fetch(offset, count)
+				if err == nil && int64(len(data)) != count {
+					err = fmt.Errorf("Short read at offset %d: got %d of %d bytes", offset, len(data), count)
+				}
+				c <- rangeChunk{data: data, err: err}
+			}()
+		}
+	}()
+	return r
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		c, ok := <-r.chunks
+		if !ok {
+			r.err = io.EOF
+			continue
+		}
+		chunk := <-c
+		r.buf, r.err = chunk.data, chunk.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close stops fetching chunks that were not read yet.
+func (r *RangeReader) Close() error {
+	close(r.stop)
+	return nil
+}
@@ -0,0 +1,231 @@
+package nzbackup
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SchemaFileName is the md file of an increment describing its tables.
+const SchemaFileName = "schema.xml"
+
+// Table is a table described in schema.xml.
+type Table struct {
+	Database string
+	Schema   string
+	Name     string
+	ObjID    string
+}
+
+func (t Table) String() string {
+	return strings.Join([]string{t.Database, t.Schema, t.Name}, ".")
+}
+
+// element is an open element while schema.xml is decoded. fields holds its
+// attributes and the text of its simple child elements, by upper case name.
+type element struct {
+	name   string
+	fields map[string]string
+	text   strings.Builder
+}
+
+func (e *element) field(name string) string {
+	return strings.TrimSpace(e.fields[name])
+}
+
+var objID = regexp.MustCompile(`^[0-9]+$`)
+
+// ParseSchema returns the tables described by a schema.xml file. Every TABLE
+// element describes a table by its NAME and OBJID, the object id its data
+// files are named after, given as attributes or child elements. The schema
+// and database of a table are the NAME of its enclosing SCHEMA and DATABASE
+// elements, or its own SCHEMA and DATABASE fields; tables without a database
+// belong to database. A TABLE without a name or numeric object id, or a file
+// without any table, is an error rather than skipped, so that a schema.xml
+// laid out differently is not mistaken for an empty backup.
+func ParseSchema(data []byte, database string) ([]Table, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// schema.xml may declare an encoding other than UTF-8; names are plain identifiers
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+
+	var stack []*element
+	var tables []Table
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %v", SchemaFileName, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &element{name: strings.ToUpper(t.Name.Local), fields: make(map[string]string)}
+			for _, attr := range t.Attr {
+				e.fields[strings.ToUpper(attr.Name.Local)] = attr.Value
+			}
+			stack = append(stack, e)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				if _, ok := parent.fields[e.name]; !ok {
+					parent.fields[e.name] = e.text.String()
+				}
+			}
+			if e.name != "TABLE" {
+				continue
+			}
+			table := Table{Database: database, Schema: e.field("SCHEMA"), Name: e.field("NAME"), ObjID: e.field("OBJID")}
+			if table.Name == "" || !objID.MatchString(table.ObjID) {
+				return nil, fmt.Errorf("Invalid %s: TABLE %d has no NAME or no numeric OBJID", SchemaFileName, len(tables)+1)
+			}
+			for i := len(stack) - 1; i >= 0; i-- {
+				switch stack[i].name {
+				case "SCHEMA":
+					if table.Schema == "" {
+						table.Schema = stack[i].field("NAME")
+					}
+				case "DATABASE":
+					if db := stack[i].field("NAME"); db != "" {
+						table.Database = db
+					}
+				}
+			}
+			if db := e.field("DATABASE"); db != "" {
+				table.Database = db
+			}
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("No TABLE found in %s", SchemaFileName)
+	}
+	return tables, nil
+}
+
+// TableFilter selects tables by db.schema.table patterns. Each part may use
+// shell globs; schema.table and table patterns match any database and schema.
+// Names are compared case insensitively.
+type TableFilter struct {
+	patterns [][]string
+}
+
+// ParseTableFilter parses a comma separated list of table patterns.
+func ParseTableFilter(spec string) (*TableFilter, error) {
+	f := &TableFilter{}
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		parts := strings.Split(strings.ToUpper(pattern), ".")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("Invalid table %s. Use db.schema.table, schema.table or table", pattern)
+		}
+		for _, part := range parts {
+			if _, err := path.Match(part, ""); err != nil || part == "" {
+				return nil, fmt.Errorf("Invalid table %s", pattern)
+			}
+		}
+		for len(parts) < 3 {
+			parts = append([]string{"*"}, parts...)
+		}
+		f.patterns = append(f.patterns, parts)
+	}
+	if len(f.patterns) == 0 {
+		return nil, fmt.Errorf("No table given")
+	}
+	return f, nil
+}
+
+// Match reports whether the table matches one of the patterns.
+func (f *TableFilter) Match(t Table) bool {
+	names := []string{strings.ToUpper(t.Database), strings.ToUpper(t.Schema), strings.ToUpper(t.Name)}
+	for _, parts := range f.patterns {
+		matched := true
+		for i, part := range parts {
+			if ok, _ := path.Match(part, names[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSchemaPath reports whether relpath names the schema.xml of an increment.
+func IsSchemaPath(relpath string) bool {
+	return path.Base(filepath.ToSlash(relpath)) == SchemaFileName && ParsePath(relpath).Role == "md"
+}
+
+var dataFile = regexp.MustCompile(`^([0-9]+)\.`)
+
+// DataObjectID returns the object id of the table a data file such as
+// data/200221.full.1.1 belongs to.
+func DataObjectID(relpath string) (string, bool) {
+	if ParsePath(relpath).Role != "data" {
+		return "", false
+	}
+	m := dataFile.FindStringSubmatch(path.Base(filepath.ToSlash(relpath)))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// KeepForTables reports whether relpath is needed to restore the tables with
+// the given object ids: every file except the data files of other tables.
+func KeepForTables(relpath string, objids map[string]bool) bool {
+	objid, ok := DataObjectID(relpath)
+	return !ok || objids[objid]
+}
+
+// Resolve reads the schema.xml files among relpaths with readSchema and
+// returns the object ids and descriptions of the matching tables. It fails
+// when there is no schema.xml or no table matches.
+func (f *TableFilter) Resolve(relpaths []string, readSchema func(relpath string) ([]byte, error)) (map[string]bool, []Table, error) {
+	objids := make(map[string]bool)
+	var matched []Table
+	schemas := 0
+	for _, relpath := range relpaths {
+		if !IsSchemaPath(relpath) {
+			continue
+		}
+		schemas++
+		data, err := readSchema(relpath)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables, err := ParseSchema(data, ParsePath(relpath).Database)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v. Tables cannot be selected, download without -tables", relpath, err)
+		}
+		for _, table := range tables {
+			if f.Match(table) && !objids[table.ObjID] {
+				objids[table.ObjID] = true
+				matched = append(matched, table)
+			}
+		}
+	}
+	if schemas == 0 {
+		return nil, nil, fmt.Errorf("No %s found in the backup", SchemaFileName)
+	}
+	if len(matched) == 0 {
+		return nil, nil, fmt.Errorf("No table of the backup matches the given tables")
+	}
+	return objids, matched, nil
+}
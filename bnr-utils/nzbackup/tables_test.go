@@ -0,0 +1,60 @@
+package nzbackup
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseSchema(t *testing.T) {
+	data, err := os.ReadFile("testdata/schema.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := ParseSchema(data, "BACKUPDB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Table{
+		{Database: "DB1", Schema: "ADMIN", Name: "CUSTOMERS", ObjID: "200221"},
+		{Database: "DB1", Schema: "ADMIN", Name: "ORDERS", ObjID: "200245"},
+		{Database: "DB1", Schema: "SALES", Name: "INVOICES", ObjID: "200311"},
+	}
+	if !slices.Equal(tables, want) {
+		t.Errorf("ParseSchema() = %v, want %v", tables, want)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"no table", `<ARCHIVE><DATABASE NAME="DB1"/></ARCHIVE>`, "No TABLE found"},
+		{"other layout", `<DB><RELATION TABLENAME="T1" OID="200"/></DB>`, "No TABLE found"},
+		{"table without object id", `<DATABASE NAME="DB1"><TABLE NAME="T1" OID="200"/></DATABASE>`, "no numeric OBJID"},
+		{"table without name", `<DATABASE NAME="DB1"><TABLE OBJID="200"/></DATABASE>`, "no NAME"},
+		{"truncated", `<DATABASE NAME="DB1"><TABLE NAME="T1" OBJID="200"/>`, "Invalid schema.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema([]byte(tt.data), "DB1")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSchema() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseSchemaDefaultDatabase(t *testing.T) {
+	tables, err := ParseSchema([]byte(`<TABLES><TABLE NAME="T1" OBJID="200" SCHEMA="S1"/></TABLES>`), "DB1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Table{{Database: "DB1", Schema: "S1", Name: "T1", ObjID: "200"}}
+	if !slices.Equal(tables, want) {
+		t.Errorf("ParseSchema() = %v, want %v", tables, want)
+	}
+}
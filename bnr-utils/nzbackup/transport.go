@@ -0,0 +1,81 @@
+package nzbackup
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// ConnMetrics counts the HTTP requests of a run and whether each of them got
+// a new connection or reused an idle one.
+type ConnMetrics struct {
+	requests atomic.Int64
+	opened   atomic.Int64
+	reused   atomic.Int64
+}
+
+// Requests returns the number of HTTP requests sent, retries included.
+func (m *ConnMetrics) Requests() int64 {
+	return m.requests.Load()
+}
+
+func (m *ConnMetrics) String() string {
+	opened, reused := m.opened.Load(), m.reused.Load()
+	pct := 0.0
+	if opened+reused > 0 {
+		pct = 100 * float64(reused) / float64(opened+reused)
+	}
+	return fmt.Sprintf("%d HTTP requests, %d connections opened, %d reused (%.1f%%)", m.Requests(), opened, reused, pct)
+}
+
+// meteredTransport records every request and connection in its metrics.
+type meteredTransport struct {
+	base    http.RoundTripper
+	metrics *ConnMetrics
+}
+
+func (t meteredTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.metrics.requests.Add(1)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.metrics.reused.Add(1)
+			} else {
+				t.metrics.opened.Add(1)
+			}
+		},
+	}
+	return t.base.RoundTrip(r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+}
+
+// NewHTTPClient returns the client every request of a run goes through, so
+// that connections are reused across files instead of opened per file. It
+// keeps up to idleConns connections per host alive, which should be
+// paralleljobs x streams for no transfer to wait for a new connection. HTTP/2
+// is only negotiated with http2, as a few connections multiplexing every
+// stream are usually slower for bulk transfers than one connection per
+// stream. metrics may be nil.
+func NewHTTPClient(idleConns int, http2 bool, metrics *ConnMetrics) *http.Client {
+	idleConns = max(idleConns, 1)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.MaxIdleConns = max(idleConns, 100)
+	transport.MaxIdleConnsPerHost = idleConns
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ForceAttemptHTTP2 = http2
+	transport.Protocols = new(http.Protocols)
+	transport.Protocols.SetHTTP1(true)
+	transport.Protocols.SetHTTP2(http2)
+
+	client := &http.Client{Transport: transport}
+	if metrics != nil {
+		client.Transport = meteredTransport{base: transport, metrics: metrics}
+	}
+	return client
+}
@@ -0,0 +1,207 @@
+package nzbackup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// DataMarkerName is the file nzbackup writes into the data directory of an increment.
+const DataMarkerName = "data.marker"
+
+// ValidateUpload checks every local backupset an upload of the given npshost,
+// database and backupset covers, where empty names select all of them, and
+// describes every problem found. Warnings describe what could not be checked
+// and do not make the backup invalid.
+func ValidateUpload(dirs []string, host, db, backupset string) (problems []string, warnings []string, err error) {
+	local, err := ScanLocal(dirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, h := range local.Hosts() {
+		if host != "" && h != host {
+			continue
+		}
+		for _, d := range local.Databases(h) {
+			if db != "" && d != db {
+				continue
+			}
+			for _, bs := range local.Backupsets(h, d) {
+				if backupset != "" && bs != backupset {
+					continue
+				}
+				found, unchecked, err := ValidateBackupset(dirs, filepath.Join("Netezza", h, d, bs))
+				if err != nil {
+					return nil, nil, err
+				}
+				problems = append(problems, found...)
+				warnings = append(warnings, unchecked...)
+			}
+		}
+	}
+	return problems, warnings, nil
+}
+
+// ValidateBackupset checks that a backupset directory, relative to the backup
+// directories, looks like complete nzbackup output: every increment has one
+// FULL, DIFF or CUMU directory with md and data, its md holds contents.txt and
+// schema.xml, its data holds data.marker, the increment chain is unbroken and
+// the data files of the tables in schema.xml exist and are not empty. The md
+// files are expected in the first directory, data in any of them. Named pipes
+// stand in for files nzbackup has yet to write. A schema.xml that cannot be
+// parsed only skips the check of the data files of its increment, with a
+// warning, as its layout is not documented.
+func ValidateBackupset(dirs []string, bsdir string) (problems []string, warnings []string, err error) {
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", bsdir)+fmt.Sprintf(format, args...))
+	}
+
+	numbers := make(map[int]bool)
+	for _, dir := range dirs {
+		names, err := subdirs(filepath.Join(dir, bsdir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			number, err := strconv.Atoi(name)
+			if err != nil || number < 1 {
+				report("unexpected directory %s", name)
+				continue
+			}
+			numbers[number] = true
+		}
+	}
+	if len(numbers) == 0 {
+		report("no increment found")
+		return problems, nil, nil
+	}
+
+	sorted := make([]int, 0, len(numbers))
+	for number := range numbers {
+		sorted = append(sorted, number)
+	}
+	sort.Ints(sorted)
+
+	var incs []Increment
+	for _, number := range sorted {
+		incdir := filepath.Join(bsdir, strconv.Itoa(number))
+		var types []string
+		for _, incType := range []string{IncrementFull, IncrementDiff, IncrementCumu} {
+			if anyExists(dirs, filepath.Join(incdir, incType)) {
+				types = append(types, incType)
+			}
+		}
+		if len(types) != 1 {
+			report("increment %d must have exactly one FULL, DIFF or CUMU directory, found %d", number, len(types))
+			continue
+		}
+		inc := Increment{Number: number, Type: types[0]}
+		incs = append(incs, inc)
+		found, unchecked, err := validateIncrement(dirs, filepath.Join(incdir, inc.Type), inc)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, problem := range found {
+			report("%s", problem)
+		}
+		for _, warning := range unchecked {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", bsdir, warning))
+		}
+	}
+
+	if len(incs) > 0 {
+		if _, err := IncrementChain(incs, incs[len(incs)-1].Number); err != nil {
+			report("%v", err)
+		}
+	}
+	return problems, warnings, nil
+}
+
+func validateIncrement(dirs []string, incdir string, inc Increment) (problems []string, warnings []string, err error) {
+	mddir := filepath.Join(dirs[0], incdir, "md")
+	if !exists(mddir) {
+		problems = append(problems, fmt.Sprintf("increment %s has no md directory in %s", inc, dirs[0]))
+	} else {
+		for _, name := range []string{ContentsFileName, SchemaFileName} {
+			if !exists(filepath.Join(mddir, name)) {
+				problems = append(problems, fmt.Sprintf("increment %s has no md/%s", inc, name))
+			}
+		}
+	}
+	datadir := filepath.Join(incdir, "data")
+	if !anyExists(dirs, datadir) {
+		problems = append(problems, fmt.Sprintf("increment %s has no data directory", inc))
+		return problems, nil, nil
+	}
+	if !anyExists(dirs, filepath.Join(datadir, DataMarkerName)) {
+		problems = append(problems, fmt.Sprintf("increment %s has no data/%s", inc, DataMarkerName))
+	}
+
+	// a schema.xml still to be written through a named pipe cannot be read
+	// without taking it from the upload
+	schema := filepath.Join(mddir, SchemaFileName)
+	if info, err := os.Stat(schema); err != nil || IsStream(info) {
+		return problems, nil, nil
+	}
+	data, err := os.ReadFile(schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	tables, err := ParseSchema(data, "")
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("increment %s: data files not checked: %v", inc, err))
+		return problems, warnings, nil
+	}
+
+	// data files of every table over all locations
+	files := make(map[string][]os.FileInfo)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(dir, datadir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			m := dataFile.FindStringSubmatch(entry.Name())
+			if m == nil || entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil, nil, err
+			}
+			files[m[1]] = append(files[m[1]], info)
+		}
+	}
+	for _, table := range tables {
+		// DIFF and CUMU increments only hold data of changed tables
+		if len(files[table.ObjID]) == 0 && inc.Type == IncrementFull {
+			problems = append(problems, fmt.Sprintf("increment %s has no data file of table %s (objid %s)", inc, table.Name, table.ObjID))
+		}
+		for _, info := range files[table.ObjID] {
+			// a named pipe has no size until nzbackup writes through it
+			if info.Size() == 0 && !IsStream(info) {
+				problems = append(problems, fmt.Sprintf("increment %s: data file %s of table %s is empty", inc, info.Name(), table.Name))
+			}
+		}
+	}
+	return problems, nil, nil
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func anyExists(dirs []string, relpath string) bool {
+	for _, dir := range dirs {
+		if exists(filepath.Join(dir, relpath)) {
+			return true
+		}
+	}
+	return false
+}
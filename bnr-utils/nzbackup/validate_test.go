@@ -0,0 +1,67 @@
+package nzbackup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const validSchema = `<DATABASE NAME="DB"><TABLE NAME="T1" OBJID="200"/></DATABASE>`
+
+// writeBackupset lays out a FULL increment of one table under dir, with the
+// given schema.xml and a data file created by makeData.
+func writeBackupset(t *testing.T, dir string, schema string, makeData func(path string) error) {
+	t.Helper()
+	incdir := filepath.Join(dir, "Netezza", "host", "db", "20240101000000", "1", IncrementFull)
+	files := map[string]string{
+		"md/" + ContentsFileName: "db,1,0\n",
+		"md/" + SchemaFileName:   schema,
+		"data/" + DataMarkerName: "",
+	}
+	for name, content := range files {
+		path := filepath.Join(incdir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := filepath.Join(incdir, "data", "200.full.1.1")
+	if err := makeData(data); err != nil {
+		t.Skipf("cannot create %s: %v", data, err)
+	}
+}
+
+func TestValidateUpload(t *testing.T) {
+	rows := func(path string) error { return os.WriteFile(path, []byte("rows"), 0644) }
+	empty := func(path string) error { return os.WriteFile(path, nil, 0644) }
+	tests := []struct {
+		name     string
+		schema   string
+		makeData func(path string) error
+		problems int
+		warnings int
+	}{
+		{"data file", validSchema, rows, 0, 0},
+		{"empty data file", validSchema, empty, 1, 0},
+		{"named pipe", validSchema, MakeFifo, 0, 0},
+		{"schema.xml of another layout", `<DB><RELATION TABLENAME="T1"/></DB>`, empty, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeBackupset(t, dir, tt.schema, tt.makeData)
+			problems, warnings, err := ValidateUpload([]string{dir}, "host", "db", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != tt.problems {
+				t.Errorf("ValidateUpload() problems = %q, want %d", problems, tt.problems)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("ValidateUpload() warnings = %q, want %d", warnings, tt.warnings)
+			}
+		})
+	}
+}
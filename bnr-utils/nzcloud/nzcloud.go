@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"netezza-utils/bnr-utils/nzbackup"
+)
+
+var commands = []command{
+	{"upload", "Upload a local nzbackup backup to the cloud",
+		[]string{"npshost", "db", "backupset", "dir", "paralleljobs", "streams", "blocksize", "min-blocksize", "max-blocksize", "checksum", "skip-validation", "stdin",
+			"lock-mode", "retain-days", "retain-until", "legal-hold", "tags", "require-immutable"},
+		func(set map[string]string) ([]string, error) {
+			return []string{"-upload"}, nil
+		}},
+	{"download", "Download a backup from the cloud for nzrestore",
+		[]string{"npshost", "db", "backupset", "before", "dir", "paralleljobs", "streams", "blocksize", "checksum",
+			"increment", "as-of", "tables", "restore-as-host", "restore-as-db", "fifo", "allow-incomplete"},
+		func(set map[string]string) ([]string, error) {
+			return []string{"-download"}, nil
+		}},
+	{"list", "List the backupsets in the cloud, or in the local directories with -local",
+		[]string{"npshost", "db", "backupset", "dir", "local", "hierarchical", "paralleljobs"},
+		func(set map[string]string) ([]string, error) {
+			if set["local"] == "true" {
+				return []string{"-list-local"}, nil
+			}
+			return []string{"-list"}, nil
+		}},
+	{"verify", "Check a backupset in the cloud against its manifest without downloading it",
+		[]string{"npshost", "db", "backupset", "before", "allow-incomplete"},
+		func(set map[string]string) ([]string, error) {
+			return []string{"-verify"}, nil
+		}},
+	{"inspect", "Report the increments and tables of a backupset from its md files",
+		[]string{"npshost", "db", "backupset", "before"},
+		func(set map[string]string) ([]string, error) {
+			return []string{"-inspect"}, nil
+		}},
+	{"diff", "Compare the tables of two backupsets given with -backupsets A,B",
+		[]string{"npshost", "db", "backupsets"},
+		func(set map[string]string) ([]string, error) {
+			if set["backupsets"] == "" {
+				return nil, errors.New("-backupsets is required")
+			}
+			return nil, nil
+		}},
+	{"rehydrate", "Restore archived objects of a backupset, or report their state with -status",
+		[]string{"npshost", "db", "backupset", "before", "status", "wait", "tier", "priority", "days", "poll-interval"},
+		func(set map[string]string) ([]string, error) {
+			if set["status"] == "true" {
+				return []string{"-rehydrate-status"}, nil
+			}
+			return []string{"-rehydrate"}, nil
+		}},
+	{"copy", "Copy a backupset to the unique ID given with -to, within the bucket",
+		[]string{"npshost", "db", "backupset", "before", "paralleljobs", "blocksize", "min-blocksize", "max-blocksize", "to"},
+		func(set map[string]string) ([]string, error) {
+			if set["to"] == "" {
+				return nil, errors.New("-to is required")
+			}
+			return nil, nil
+		}},
+	{"delete", "Delete a backupset from the cloud",
+		[]string{"npshost", "db", "backupset", "yes"},
+		func(set map[string]string) ([]string, error) {
+			if set["backupset"] == "" || set["backupset"] == "latest" {
+				return nil, errors.New("-backupset is required and must name a backupset ID")
+			}
+			if set["yes"] != "true" {
+				return nil, errors.New("Refusing to delete without -yes")
+			}
+			return []string{"-delete"}, nil
+		}},
+	{"check", "Check credentials, bucket and permissions by writing, reading and deleting a probe object",
+		nil,
+		func(set map[string]string) ([]string, error) {
+			return []string{"-check"}, nil
+		}},
+	{"bench", "Measure upload and download throughput with synthetic data and recommend streams, blocksize and paralleljobs",
+		[]string{"dir", "streams", "blocksize", "paralleljobs", "matrix", "size"},
+		func(set map[string]string) ([]string, error) {
+			return []string{"-bench"}, nil
+		}},
+	{"config", "Print the effective configuration with secrets masked, run as nzcloud config show",
+		nil,
+		func(set map[string]string) ([]string, error) {
+			return []string{"-show-config"}, nil
+		}},
+}
+
+// backends are the connector binaries run for each backend.
+var backends = map[string]string{
+	nzbackup.BackendS3:    "nz_s3Connector",
+	nzbackup.BackendAzure: "nz_azConnector",
+}
+
+func findOption(name string) option {
+	for _, o := range options {
+		if o.name == name {
+			return o
+		}
+	}
+	panic("unknown option " + name)
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: nzcloud <command> -backend s3|azure [options]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun nzcloud help <command> for the options of a command.\n")
+}
+
+// flagSet defines the options of a command.
+func flagSet(c command) *flag.FlagSet {
+	name := c.name
+	if name == "config" {
+		name = "config show"
+	}
+	fs := flag.NewFlagSet("nzcloud "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: nzcloud %s -backend s3|azure [options]\n\n%s\n\nOptions:\n", name, c.summary)
+		fs.PrintDefaults()
+	}
+	for _, name := range append(append([]string{}, connection...), c.options...) {
+		o := findOption(name)
+		switch o.kind {
+		case "bool":
+			fs.Bool(o.name, o.def == "true", o.usage)
+		case "int":
+			def, _ := strconv.Atoi(o.def)
+			fs.Int(o.name, def, o.usage)
+		default:
+			fs.String(o.name, o.def, o.usage)
+		}
+	}
+	return fs
+}
+
+// setting returns an option given to nzcloud, or else the value of its
+// NZ_CONNECTOR_ environment variable, which the connector reads as well.
+func setting(set map[string]string, name string) string {
+	if value, ok := set[name]; ok {
+		return value
+	}
+	return os.Getenv(nzbackup.EnvName(name))
+}
+
+// translate returns the backend binary and its arguments for a command. The
+// settings of the selected profile are left to the backend to apply.
+func translate(c command, set map[string]string, profile map[string]string) (string, []string, error) {
+	backend := setting(set, "backend")
+	if backend == "" {
+		backend = profile[nzbackup.BackendKey]
+	}
+	binary, ok := backends[backend]
+	if !ok {
+		return "", nil, fmt.Errorf("Missing or invalid -backend %q, use s3 or azure or a profile with a backend setting", backend)
+	}
+	if _, ok := set["logfiledir"]; !ok && setting(set, "logfiledir") == "" && nzbackup.ProfileValue(profile, backend, "logfiledir") == "" {
+		set["logfiledir"] = findOption("logfiledir").def
+	}
+	args, err := c.run(set)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, name := range append(append([]string{}, connection...), c.options...) {
+		value, ok := set[name]
+		if !ok {
+			continue
+		}
+		o := findOption(name)
+		target := o.s3
+		if backend == "azure" {
+			target = o.azure
+		}
+		switch {
+		case target != "":
+			if o.kind == "bool" {
+				args = append(args, "-"+target+"="+value)
+			} else {
+				args = append(args, "-"+target, value)
+			}
+		case o.s3 == "" && o.azure == "":
+			// handled by nzcloud or the command itself
+		default:
+			return "", nil, fmt.Errorf("-%s is not supported by the %s backend", name, backend)
+		}
+	}
+	return binary, args, nil
+}
+
+// locate finds a backend binary next to nzcloud, or else in the PATH.
+func locate(binary string) (string, error) {
+	if self, err := os.Executable(); err == nil {
+		candidate := filepath.Join(filepath.Dir(self), binary)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return exec.LookPath(binary)
+}
+
+// printable returns the command line with secrets masked.
+func printable(binary string, args []string) string {
+	masked := append([]string{binary}, args...)
+	for i := 1; i < len(masked)-1; i++ {
+		switch masked[i] {
+		case "-secret-key", "-key":
+			masked[i+1] = "****"
+		}
+	}
+	return strings.Join(masked, " ")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	args := os.Args[2:]
+	switch name {
+	case "-h", "-help", "--help":
+		usage()
+		return
+	case "help":
+		if len(args) == 0 {
+			usage()
+			return
+		}
+		c, ok := findCommand(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+			usage()
+			os.Exit(2)
+		}
+		flagSet(c).Usage()
+		return
+	}
+	c, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if c.name == "config" {
+		if len(args) == 0 || args[0] != "show" {
+			flagSet(c).Usage()
+			os.Exit(2)
+		}
+		args = args[1:]
+	}
+
+	fs := flagSet(c)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+	// only the options given are passed on, leaving the backend defaults
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected argument %q. Options start with '-'\n", fs.Arg(0))
+		os.Exit(2)
+	}
+
+	// the profile may name the backend and the log file directory
+	profile, _, err := nzbackup.LoadProfile(setting(set, "config"), setting(set, "profile"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nzcloud %s: %v\n", c.name, err)
+		os.Exit(2)
+	}
+
+	binary, backendArgs, err := translate(c, set, profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nzcloud %s: %v\n", c.name, err)
+		os.Exit(2)
+	}
+	if set["dry-run"] == "true" {
+		fmt.Println(printable(binary, backendArgs))
+		return
+	}
+	path, err := locate(binary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nzcloud: cannot find %s next to nzcloud or in the PATH: %v\n", binary, err)
+		os.Exit(1)
+	}
+
+	cmd := exec.Command(path, backendArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nzcloud: %v\n", err)
+		os.Exit(1)
+	}
+}
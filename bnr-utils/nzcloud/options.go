@@ -0,0 +1,85 @@
+package main
+
+// option is a flag of nzcloud and the flags it becomes for each backend.
+// An empty backend flag means the backend does not support the option.
+type option struct {
+	name  string
+	kind  string // string, int or bool
+	def   string
+	usage string
+	s3    string
+	azure string
+}
+
+var options = []option{
+	{"backend", "string", "", "Cloud backend: s3 (AWS S3, IBM Cloud Object Storage) or azure (Azure blob storage)", "", ""},
+	{"bucket", "string", "", "S3 bucket or Azure container", "bucket-url", "container"},
+	{"access-key", "string", "", "S3 access key id", "access-key", ""},
+	{"secret-key", "string", "", "S3 secret access key or Azure storage account key", "secret-key", "key"},
+	{"account", "string", "", "Azure storage account name", "", "storage-account"},
+	{"region", "string", "", "S3 region of the bucket", "region", ""},
+	{"endpoint", "string", "", "S3 endpoint URL, required for IBM Cloud Object Storage", "endpoint", ""},
+	{"unique-id", "string", "", "Unique ID the backups are stored under in the bucket", "unique-id", "uniqueid"},
+	{"logfiledir", "string", "/tmp", "Directory of the log file", "logfiledir", "logfiledir"},
+	{"config", "string", "", "Configuration file with named profiles, default ~/.nzconnector.conf", "config", "config"},
+	{"profile", "string", "", "Profile of the configuration file supplying the options not given on the command line", "profile", "profile"},
+	{"http2", "bool", "false", "Use HTTP/2 when the endpoint supports it instead of a connection per stream", "http2", "http2"},
+
+	{"npshost", "string", "", "Name of the NPS host as it appears in the backups", "npshost", "npshost"},
+	{"db", "string", "", "Database name", "db", "db"},
+	{"backupset", "string", "", "Backupset ID. Use latest for the newest backupset in the cloud", "backupset", "backupset"},
+	{"before", "string", "", "Use the newest backupset started at or before this time (YYYYMMDDhhmmss, YYYY-MM-DD hh:mm:ss or RFC3339)", "before", "before"},
+	{"dir", "string", "", "Backup directories, separated by spaces", "dir", "dir"},
+
+	{"paralleljobs", "int", "6", "Number of files to transfer in parallel", "paralleljobs", "paralleljobs"},
+	{"streams", "int", "16", "Number of blocks of a file to transfer in parallel", "streams", "streams"},
+	{"blocksize", "int", "100", "Block size in MB", "blocksize", "blocksize"},
+	{"min-blocksize", "int", "0", "Smallest block size in MB uploads may use when a file is too large for the block count limit", "min-blocksize", "min-blocksize"},
+	{"max-blocksize", "int", "0", "Largest block size in MB uploads may use", "max-blocksize", "max-blocksize"},
+	{"checksum", "bool", "false", "Record SHA-256 checksums in the manifest on upload and verify them on download, reading every file a second time", "checksum", "checksum"},
+
+	{"skip-validation", "bool", "false", "Upload without checking that the local backup is complete nzbackup output", "skip-validation", "skip-validation"},
+	{"stdin", "string", "", "Upload the standard input as this file path relative to the backupset directory", "stdin", "stdin"},
+	{"lock-mode", "string", "", "Retention mode of uploaded objects: GOVERNANCE or COMPLIANCE for s3, Unlocked or Locked for azure", "object-lock-mode", "immutability-mode"},
+	{"retain-days", "int", "0", "Number of days uploaded objects are protected against deletion and overwrite", "retain-days", "retain-days"},
+	{"retain-until", "string", "", "Date (YYYY-MM-DD or RFC3339) until which uploaded objects are protected", "retain-until", "retain-until"},
+	{"legal-hold", "bool", "false", "Place a legal hold on uploaded objects", "legal-hold", "legal-hold"},
+	{"tags", "bool", "false", "Also add the backup description stored in the object metadata as tags", "tags", "tags"},
+	{"require-immutable", "bool", "false", "Refuse to upload unless the bucket protects objects against deletion", "require-immutable", "require-immutable"},
+
+	{"increment", "int", "0", "Download only the increments needed to restore up to this increment", "increment", "increment"},
+	{"as-of", "string", "", "Download only the increments needed to restore as of this time", "as-of", "as-of"},
+	{"tables", "string", "", "Download only the data of these tables, a comma separated list of db.schema.table that may contain globs", "tables", "tables"},
+	{"restore-as-host", "string", "", "NPS host name the backup is restored under, if different from -npshost", "restore-as-host", "restore-as-host"},
+	{"restore-as-db", "string", "", "Database name the backup is restored under, if different from -db", "restore-as-db", "restore-as-db"},
+	{"fifo", "bool", "false", "Stream the table data files into named pipes for nzrestore instead of writing them to disk", "fifo", "fifo"},
+	{"allow-incomplete", "bool", "false", "Use backupsets without a commit object or with missing files, only logging a warning", "allow-incomplete", "allow-incomplete"},
+
+	{"local", "bool", "false", "List the backups in the local directories given by -dir instead of the cloud", "", ""},
+	{"hierarchical", "bool", "false", "Find the backupsets level by level with delimiter listings and list them -paralleljobs at a time", "hierarchical", "hierarchical"},
+	{"backupsets", "string", "", "The two backupsets to compare, given as A,B", "diff", "diff"},
+	{"status", "bool", "false", "Only report how many objects are still archived", "", ""},
+	{"wait", "bool", "false", "Wait until all objects are readable", "wait-rehydrate", "wait-rehydrate"},
+	{"tier", "string", "", "Retrieval tier: Expedited, Standard or Bulk for s3, Hot or Cool for azure", "rehydrate-tier", "rehydrate-tier"},
+	{"priority", "string", "", "Rehydrate priority: Standard or High", "", "rehydrate-priority"},
+	{"days", "int", "7", "Number of days the restored copy of an archived object stays available", "rehydrate-days", ""},
+	{"poll-interval", "int", "15", "Minutes to wait between checks while waiting", "poll-interval", "poll-interval"},
+	{"to", "string", "", "Unique ID to copy the backupset to", "copy-to", "copy-to"},
+	{"matrix", "string", "", "Settings to try, as streams=N,N blocksize=N,N paralleljobs=N,N", "bench-matrix", "bench-matrix"},
+	{"size", "int", "64", "Size in MB of each synthetic file", "bench-size", "bench-size"},
+	{"yes", "bool", "false", "Confirm the deletion", "", ""},
+	{"dry-run", "bool", "false", "Print the backend command instead of running it", "", ""},
+}
+
+// connection are the options every command accepts.
+var connection = []string{"backend", "bucket", "access-key", "secret-key", "account", "region", "endpoint", "unique-id", "logfiledir", "config", "profile", "http2", "dry-run"}
+
+// command is a subcommand of nzcloud.
+type command struct {
+	name    string
+	summary string
+	// options accepted in addition to the connection options
+	options []string
+	// run returns the backend flags selecting the operation
+	run func(set map[string]string) ([]string, error)
+}